@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+)
+
+// The API of `blt serve` answers with JSON, entries as --json prints them:
+//
+//	GET  /sections                  the sections with their entries
+//	GET  /entries                   the entries, by ?type=, status, tag, since and until
+//	POST /entries                   add an entry given as {"type", "text", "date"}
+//	POST /entries/{ref}/complete    complete a task, by number or id:N
+//	GET  /search?q=TEXT             the entries containing TEXT, ignoring case, by ?type=
+//
+// An error is answered as {"error": message}.
+func (s *logServer) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/sections", s.api(apiHandlers{http.MethodGet: s.listSections}))
+	mux.HandleFunc("/entries", s.api(apiHandlers{http.MethodGet: s.listEntries, http.MethodPost: s.addEntry}))
+	mux.HandleFunc("/entries/", s.api(apiHandlers{http.MethodPost: s.completeEntry}))
+	mux.HandleFunc("/search", s.api(apiHandlers{http.MethodGet: s.searchEntries}))
+}
+
+// An apiHandler answers a request with a status and what to send as JSON,
+// or a status and an error; a status of 0 with an error is a failure on the
+// server's side.
+type apiHandler func(r *http.Request, log store) (int, interface{}, error)
+
+// apiHandlers are the handlers of a path by method.
+type apiHandlers map[string]apiHandler
+
+// api answers the requests to a path with its handlers, with the log locked
+// for each.
+func (s *logServer) api(handlers apiHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.Method]
+		if !ok {
+			methods := make([]string, 0, len(handlers))
+			for method := range handlers {
+				methods = append(methods, method)
+			}
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeAPI(w, http.StatusMethodNotAllowed, apiError{"Method not allowed"})
+			return
+		}
+
+		unlock, err := s.lock()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			writeAPI(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		defer unlock()
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		log, err := newStore(nil, s.path, "serve")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			writeAPI(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		status, v, err := h(r, log)
+		if err != nil {
+			if status == 0 {
+				fmt.Fprintln(os.Stderr, err)
+				status = http.StatusInternalServerError
+			}
+			v = apiError{err.Error()}
+		}
+		writeAPI(w, status, v)
+	}
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeAPI(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, v)
+}
+
+// jsonSection is a section as the API sends it.
+type jsonSection struct {
+	Header string `json:"header"`
+	// Date is the date of a date section, and Collection the name of a
+	// collection.
+	Date       string      `json:"date,omitempty"`
+	Collection string      `json:"collection,omitempty"`
+	Entries    []jsonEntry `json:"entries"`
+}
+
+// loadSections returns the sections of the log as the API sends them.
+func loadSections(log store) ([]jsonSection, error) {
+	lines, err := log.Load()
+	if err != nil {
+		return nil, err
+	}
+	sections, err := newLog(lines).Sections()
+	if err != nil {
+		return nil, parseError(err)
+	}
+	numbers := bulletlog.LineNumbers(lines)
+	result := make([]jsonSection, 0, len(sections))
+	for _, section := range sections {
+		js := jsonSection{Header: section.Header, Entries: []jsonEntry{}}
+		if section.Date != nil {
+			js.Date = section.Date.Format(dateFormat)
+		}
+		if bulletlog.IsCollection(section.Header) {
+			js.Collection = bulletlog.CollectionName(section.Header)
+		}
+		for _, e := range section.Entries {
+			entry := newJSONEntry(lines[e.Line], numbers[e.Line], section.Date)
+			entry.Collection = js.Collection
+			js.Entries = append(js.Entries, entry)
+		}
+		result = append(result, js)
+	}
+	return result, nil
+}
+
+func (s *logServer) listSections(r *http.Request, log store) (int, interface{}, error) {
+	sections, err := loadSections(log)
+	return http.StatusOK, sections, err
+}
+
+func (s *logServer) listEntries(r *http.Request, log store) (int, interface{}, error) {
+	q := r.URL.Query()
+	typ, status, tag := q.Get("type"), q.Get("status"), strings.TrimPrefix(q.Get("tag"), "#")
+	if _, ok := markers[typ]; typ != "" && !ok {
+		return http.StatusBadRequest, nil, fmt.Errorf("Unknown entry type: %q", typ)
+	}
+	var since, until *time.Time
+	for _, bound := range []struct {
+		name string
+		date **time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if v := q.Get(bound.name); v != "" {
+			t, err := parseDate(v)
+			if err != nil {
+				return http.StatusBadRequest, nil, err
+			}
+			*bound.date = &t
+		}
+	}
+
+	sections, err := loadSections(log)
+	if err != nil {
+		return 0, nil, err
+	}
+	entries := []jsonEntry{}
+	for _, section := range sections {
+		if since != nil || until != nil {
+			date, err := time.Parse(dateFormat, section.Date)
+			if err != nil || !inRange(date, since, until) {
+				continue
+			}
+		}
+		for _, e := range section.Entries {
+			if typ != "" && e.Type != typ || status != "" && e.Status != status || tag != "" && !hasTag(e.Text, tag) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	return http.StatusOK, entries, nil
+}
+
+func (s *logServer) addEntry(r *http.Request, log store) (int, interface{}, error) {
+	var e jsonEntry
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if e.Type == "" {
+		e.Type = "note"
+	}
+	if _, ok := markers[e.Type]; !ok {
+		return http.StatusBadRequest, nil, fmt.Errorf("Unknown entry type: %q", e.Type)
+	}
+	text := foldText(e.Text)
+	if text == "" {
+		return http.StatusBadRequest, nil, errors.New("No text given")
+	}
+	date, err := getDate()
+	if err != nil {
+		return 0, nil, err
+	}
+	if e.Date != "" {
+		date, err = parseDate(e.Date)
+		if err != nil {
+			return http.StatusBadRequest, nil, err
+		}
+	}
+
+	entry, err := log.AppendEntry(date, "", fmt.Sprintf("%s %s", markers[e.Type], text))
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, newJSONEntry(entry, 0, &date), nil
+}
+
+func (s *logServer) completeEntry(r *http.Request, log store) (int, interface{}, error) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/entries/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "complete" {
+		return http.StatusNotFound, nil, errors.New("Not found")
+	}
+	ref := parts[0]
+
+	// A task that is not open is there, but cannot be completed.
+	var number int
+	var date *time.Time
+	status := 0
+	find := func(lines []string) (int, error) {
+		i, err := findEntry(lines, ref, false)
+		if err != nil {
+			status = http.StatusNotFound
+			return 0, err
+		}
+		if !isListedTask(lines[i]) {
+			status = http.StatusConflict
+			return 0, fmt.Errorf("%s is not an open task", ref)
+		}
+		number, date = bulletlog.LineNumber(lines, i), sectionDate(lines, i)
+		return i, nil
+	}
+	var done string
+	err := log.UpdateEntry(find, func(line string) (string, error) {
+		l := newLog([]string{line})
+		err := l.Complete(0)
+		done = l.Lines[0]
+		return done, err
+	})
+	if err != nil {
+		return status, nil, err
+	}
+	return http.StatusOK, newJSONEntry(done, number, date), nil
+}
+
+func (s *logServer) searchEntries(r *http.Request, log store) (int, interface{}, error) {
+	query, typ := r.URL.Query().Get("q"), r.URL.Query().Get("type")
+	if query == "" {
+		return http.StatusBadRequest, nil, errors.New("No query given")
+	}
+	if _, ok := markers[typ]; typ != "" && !ok {
+		return http.StatusBadRequest, nil, fmt.Errorf("Unknown entry type: %q", typ)
+	}
+	lower := strings.ToLower(query)
+	found, err := log.Search([]string{query}, func(line string) bool {
+		return (typ == "" || entryType(line) == typ) && strings.Contains(strings.ToLower(taskText(line)), lower)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	entries := []jsonEntry{}
+	for _, f := range found {
+		e := newJSONEntry(f.Line, f.Number, f.Date)
+		if bulletlog.IsCollection(f.Header) {
+			e.Collection = bulletlog.CollectionName(f.Header)
+		}
+		entries = append(entries, e)
+	}
+	return http.StatusOK, entries, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+	"github.com/urfave/cli/v2"
+)
+
+// archiveMonthFormat names an archive by the month its sections are from.
+const archiveMonthFormat = "2006-01"
+
+// getArchiveDir returns where the sections archived from the log at path are
+// kept: BULLETLOG_ARCHIVE_DIR, or an archive directory next to the log. Each
+// month is a plain-text log of its own, such as archive/2023-12.md.
+func getArchiveDir(path string) string {
+	if dir := getSetting("BULLETLOG_ARCHIVE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(path), "archive")
+}
+
+// listArchives returns the archive files of the log at path in the order the
+// log keeps its sections: newest first, or oldest first when they ascend.
+func listArchives(path string) ([]string, error) {
+	dir := getArchiveDir(path)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ioError(err)
+	}
+
+	var files []string
+	for _, e := range entries {
+		month := strings.TrimSuffix(e.Name(), ".md")
+		if _, err := time.Parse(archiveMonthFormat, month); err == nil && !e.IsDir() && month != e.Name() {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	if !sectionsAscending() {
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+	}
+	return files, nil
+}
+
+// archiveLog moves the date sections from before the month given with
+// --before, by default and at the latest the current one, out of the log into
+// a file per month in getArchiveDir. Sections already archived for a day are
+// merged.
+func archiveLog(c *cli.Context) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	current := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	before := current
+	if s := c.String("before"); s != "" {
+		before, err = time.Parse(archiveMonthFormat, s)
+		if err != nil {
+			return fmt.Errorf("Invalid month: %q; use YYYY-MM", s)
+		}
+		// The current month holds today and the tasks still open.
+		if before.After(current) {
+			return fmt.Errorf("Cannot archive the current month or later; --before takes a month up to %s", current.Format(archiveMonthFormat))
+		}
+	}
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	log, err := newStore(c, path, c.Command.FullName())
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	count := 0
+	kept, months, err := splitMonths(lines, func(date time.Time) bool {
+		if date.Before(before) {
+			count++
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Printf("Nothing to archive before %s\n", before.Format(archiveMonthFormat))
+		return nil
+	}
+
+	names := make([]string, 0, len(months))
+	for month := range months {
+		names = append(names, month)
+	}
+	sort.Strings(names)
+	dir := getArchiveDir(path)
+	for _, month := range names {
+		file := filepath.Join(dir, month+".md")
+		if c.Bool("dry-run") {
+			fmt.Printf("Would archive %d lines into %s\n", len(months[month]), file)
+			continue
+		}
+		archived, err := readArchive(file)
+		if err != nil {
+			return err
+		}
+		archived, _, err = mergeSections(archived, months[month])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return ioError(err)
+		}
+		if err := writeLines(file, archived); err != nil {
+			return err
+		}
+	}
+
+	// The archives are written first, so that a failure leaves the sections
+	// in the log; archiving them again merges them with their copies.
+	if err := log.Save(kept); err != nil {
+		return err
+	}
+	if !c.Bool("dry-run") {
+		fmt.Printf("Archived %d sections into %s\n", count, dir)
+	}
+	return nil
+}
+
+// splitMonths takes the date sections of lines for which split returns true
+// out of them, and returns the lines left with the lines of the sections
+// taken by the month they are in.
+func splitMonths(lines []string, split func(date time.Time) bool) ([]string, map[string][]string, error) {
+	sections, err := newLog(lines).Sections()
+	if err != nil {
+		return nil, nil, parseError(err)
+	}
+	if len(sections) == 0 {
+		return lines, nil, nil
+	}
+
+	months := map[string][]string{}
+	kept := append([]string{}, lines[:sections[0].Start]...)
+	for _, s := range sections {
+		if s.Date == nil || !split(*s.Date) {
+			kept = append(kept, lines[s.Start:s.End]...)
+			continue
+		}
+		month := s.Date.Format(archiveMonthFormat)
+		months[month] = append(months[month], lines[s.Start:s.End]...)
+	}
+	return kept, months, nil
+}
+
+// readArchive returns the lines of the archive file, or nothing when there
+// is none yet.
+func readArchive(file string) ([]string, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return readLines(file)
+}
+
+// mergeSections files the sections of from into the log into: each date
+// section where it belongs by date, and each undated section into the section
+// of into with the same header, added when there is none. A section into
+// already has gets only the entries it does not hold yet, whatever their IDs,
+// so that merging twice changes nothing. An entry added with an ID into
+// already gives an entry with other text, or without one when into uses IDs,
+// gets the next free one; a task migrated keeps its ID. It returns the merged
+// lines and how many entries were added.
+func mergeSections(into []string, from []string) ([]string, int, error) {
+	sections, err := newLog(from).Sections()
+	if err != nil {
+		return nil, 0, parseError(err)
+	}
+	ids := map[int]string{}
+	next, useIDs := nextID(into)
+	for _, line := range into {
+		if id, ok := getID(line); ok && isBullet(line) {
+			ids[id] = entryKey(taskText(line))
+		}
+	}
+	// renumber returns line with an ID no other entry has, and records it.
+	renumber := func(line string) string {
+		id, ok := getID(line)
+		if !ok {
+			if !useIDs {
+				return line
+			}
+			id = next
+			first, rest := splitEntry(line)
+			line = fmt.Sprintf("%s [id:%d]%s", first, id, rest)
+		} else if text, used := ids[id]; used && text != entryKey(taskText(line)) {
+			id = next
+			line = idPattern.ReplaceAllString(line, fmt.Sprintf("[id:%d]", id))
+		}
+		ids[id] = entryKey(taskText(line))
+		if id >= next {
+			next = id + 1
+		}
+		return line
+	}
+
+	added := 0
+	for _, s := range sections {
+		body := from[s.Start+1 : s.End]
+		l := newLog(into)
+		var i int
+		found := true
+		switch {
+		case s.Date != nil:
+			i, found, err = l.FindSection(*s.Date)
+			if err != nil {
+				return nil, 0, parseError(err)
+			}
+		case s.Header == inboxHeader:
+			l.Lines, i = findInbox(l.Lines)
+		case bulletlog.IsCollection(s.Header):
+			var ok bool
+			if i, ok = findCollection(l.Lines, bulletlog.CollectionName(s.Header)); !ok {
+				l.Lines, i = findUndated(l.Lines, s.Header)
+			}
+		default:
+			l.Lines, i = findUndated(l.Lines, s.Header)
+		}
+		if !found {
+			for len(body) > 0 && strings.TrimSpace(body[0]) == "" {
+				body = body[1:]
+			}
+			body = append([]string{}, body...)
+			if n := len(body); n == 0 || strings.TrimSpace(body[n-1]) != "" {
+				body = append(body, "")
+			}
+			for j, line := range body {
+				if isBullet(line) {
+					body[j] = renumber(line)
+					added++
+				}
+			}
+			l.InsertSection(i, *s.Date, body)
+			into = l.Lines
+			continue
+		}
+
+		have := map[string]bool{}
+		for j := i + 1; j < len(l.Lines) && !bulletlog.IsHeader(l.Lines[j]); j++ {
+			have[entryKey(l.Lines[j])] = true
+		}
+		slot := ""
+		for _, line := range body {
+			if t, err := bulletlog.ParseSubHeader(line); err == nil {
+				slot = t
+			}
+			if !isBullet(line) || have[entryKey(line)] {
+				continue
+			}
+			have[entryKey(line)] = true
+			l.AppendTo(i, slot, renumber(line))
+			added++
+		}
+		into = l.Lines
+	}
+	return into, added, nil
+}
+
+// entryKey is what tells entries apart when merging: their line without the
+// ID, ignoring spacing.
+func entryKey(line string) string {
+	return strings.Join(strings.Fields(idPattern.ReplaceAllString(line, "")), " ")
+}
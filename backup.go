@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// backupTimeFormat names a backup by when it was taken, to the millisecond.
+const backupTimeFormat = "20060102T150405.000"
+
+// getBackupDir returns where backups of the log at path are kept:
+// BULLETLOG_BACKUP_DIR, or a directory next to the log.
+func getBackupDir(path string) string {
+	if dir := getSetting("BULLETLOG_BACKUP_DIR"); dir != "" {
+		return dir
+	}
+	return path + ".backups"
+}
+
+// getBackupKeep returns how many backups are kept, as set with
+// BULLETLOG_BACKUPS. Zero, the default, takes none before rewrites.
+func getBackupKeep() int {
+	keep, err := strconv.Atoi(getSetting("BULLETLOG_BACKUPS"))
+	if err != nil || keep < 0 {
+		return 0
+	}
+	return keep
+}
+
+// backup is a snapshot of the log, named by when it was taken.
+type backup struct {
+	Name string
+	Time time.Time
+}
+
+// listBackups returns the backups in dir, oldest first.
+func listBackups(dir string) ([]backup, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ioError(err)
+	}
+	var backups []backup
+	for _, f := range files {
+		t, err := time.ParseInLocation(backupTimeFormat, f.Name(), time.Local)
+		if err != nil || f.IsDir() {
+			continue
+		}
+		backups = append(backups, backup{f.Name(), t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// snapshotLog returns the content of the log at path as a backup keeps it:
+// the file as it is, or a log its storage splits as a single plain-text log,
+// which restore reads back whole.
+func snapshotLog(path string) ([]byte, error) {
+	files, err := logFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 1 {
+		content, err := ioutil.ReadFile(path)
+		return content, ioError(err)
+	}
+	r, err := openLog(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(r)
+	return content, ioError(err)
+}
+
+// backupLog copies the log at path into the backup directory, then removes
+// all but the newest keep backups. With keep 0 none are removed.
+func backupLog(path string, keep int) (string, error) {
+	content, err := snapshotLog(path)
+	if err != nil {
+		return "", err
+	}
+	dir := getBackupDir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", ioError(err)
+	}
+	name := filepath.Join(dir, time.Now().Format(backupTimeFormat))
+	err = writeFile(name, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	backups, err := listBackups(dir)
+	if err != nil || keep == 0 {
+		return name, err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0].Name)); err != nil {
+			return name, ioError(err)
+		}
+		backups = backups[1:]
+	}
+	return name, nil
+}
+
+// autoBackup backs up the log at path before it is rewritten, when backups
+// are on.
+func autoBackup(path string) error {
+	keep := getBackupKeep()
+	if keep == 0 {
+		return nil
+	}
+	_, err := backupLog(path, keep)
+	return err
+}
+
+func backupCommand(c *cli.Context) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	if c.Bool("list") {
+		backups, err := listBackups(getBackupDir(path))
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			fmt.Printf("%s  %s\n", b.Name, b.Time.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	}
+
+	name, err := backupLog(path, getBackupKeep())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up to %s\n", name)
+	return nil
+}
+
+// parseBackupTime parses the time given with restore --at: a backup's name,
+// a time as YYYYMMDDTHHMM[SS], or a date, meaning the end of that day.
+func parseBackupTime(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405", "20060102T1504"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	date, err := parseDate(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, -1, time.Local), nil
+}
+
+// restoreLog replaces the log with the latest backup, or the latest taken at
+// or before --at. The restore is a change like any other, so it can be undone.
+func restoreLog(c *cli.Context) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	dir := getBackupDir(path)
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	if at := c.String("at"); at != "" {
+		t, err := parseBackupTime(at)
+		if err != nil {
+			return err
+		}
+		i := sort.Search(len(backups), func(i int) bool { return backups[i].Time.After(t) })
+		backups = backups[:i]
+	}
+	if len(backups) == 0 {
+		return errors.New("No backup to restore")
+	}
+	b := backups[len(backups)-1]
+
+	lines, err := readLog(filepath.Join(dir, b.Name))
+	if err != nil {
+		return err
+	}
+	if err := saveLines(c, path, lines); err != nil {
+		return err
+	}
+	if !c.Bool("dry-run") {
+		fmt.Printf("Restored the backup of %s\n", b.Time.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thara/blt/pkg/bulletlog"
+	"github.com/urfave/cli/v2"
+)
+
+// exportEntry is an entry as the exporters see it.
+type exportEntry struct {
+	jsonEntry
+	Marker string
+	// Slot is the time of the `### HH:MM` sub-section holding the entry.
+	Slot string
+}
+
+// exportSection is a section of the log with the entries being exported.
+type exportSection struct {
+	Title string
+	// Date is nil for the undated sections.
+	Date    *time.Time
+	Entries []exportEntry
+	// Lines are the lines of the section as the log keeps them, less the
+	// entries left out.
+	Lines []string
+}
+
+// exporters write the sections of the log in the format they are named by.
+var exporters = map[string]func(w io.Writer, sections []exportSection) error{
+	"bulletlog":   exportBulletlog,
+	"markdown":    exportMarkdown,
+	"html":        exportHTML,
+	"csv":         exportCSV,
+	"ics":         exportICS,
+	"todotxt":     exportTodoTxt,
+	"taskwarrior": exportTaskwarrior,
+}
+
+func exportFormats() []string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// exportLog writes the log, or the date sections from --since to --until, in
+// the format given with --format.
+func exportLog(c *cli.Context) error {
+	export, ok := exporters[c.String("format")]
+	if !ok {
+		return fmt.Errorf("Unknown format: %q; use one of %s", c.String("format"), strings.Join(exportFormats(), ", "))
+	}
+	typ := c.String("type")
+	if _, ok := markers[typ]; typ != "" && !ok {
+		return fmt.Errorf("Unknown entry type: %q", typ)
+	}
+	since, until, err := getDateRange(c, "since", "until")
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	sections, err := newLog(lines).Sections()
+	if err != nil {
+		return parseError(err)
+	}
+
+	numbers := bulletlog.LineNumbers(lines)
+	var exported []exportSection
+	for _, section := range sections {
+		if (since != nil || until != nil) && (section.Date == nil || !inRange(*section.Date, since, until)) {
+			continue
+		}
+		s := exportSection{Title: sectionTitle(section), Date: section.Date, Lines: []string{lines[section.Start]}}
+		slot := ""
+		for i := section.Start + 1; i < section.End; i++ {
+			if t, err := bulletlog.ParseSubHeader(lines[i]); err == nil {
+				slot = t
+				s.Lines = append(s.Lines, lines[i])
+				continue
+			}
+			if !isBullet(lines[i]) {
+				s.Lines = append(s.Lines, lines[i])
+				continue
+			}
+			if typ != "" && entryType(lines[i]) != typ {
+				continue
+			}
+			s.Lines = append(s.Lines, lines[i])
+			s.Entries = append(s.Entries, exportEntry{
+				jsonEntry: newJSONEntry(lines[i], numbers[i], section.Date),
+				Marker:    taskMarker(lines[i]),
+				Slot:      slot,
+			})
+		}
+		exported = append(exported, s)
+	}
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	w := bufio.NewWriter(out)
+	if err := export(w, exported); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// exportBulletlog writes the sections in blt's own plain-text layout, as a
+// log kept as text would hold them.
+func exportBulletlog(w io.Writer, sections []exportSection) error {
+	var lines []string
+	for _, section := range sections {
+		lines = append(lines, section.Lines...)
+	}
+	return newLog(lines).Write(w)
+}
+
+// sectionTitle returns the heading of a section in an export.
+func sectionTitle(section bulletlog.Section) string {
+	switch {
+	case section.Date != nil:
+		return section.Date.Format("Monday, January 2, 2006")
+	case bulletlog.IsCollection(section.Header):
+		return bulletlog.CollectionName(section.Header)
+	case section.Header == futureHeader:
+		return "Future log"
+	}
+	name := strings.TrimPrefix(section.Header, "## ")
+	return strings.ToUpper(name[:1]) + strings.ToLower(name[1:])
+}
+
+// struck reports whether an entry is shown struck through: completed and
+// cancelled tasks.
+func (e exportEntry) struck() bool {
+	return e.Status == "done" || e.Status == "cancelled"
+}
+
+// exportMarkdown writes the log as Markdown, with a heading per section and
+// per sub-section, and an item per entry led by its glyph.
+func exportMarkdown(w io.Writer, sections []exportSection) error {
+	fmt.Fprintln(w, "# Bullet log")
+	for _, section := range sections {
+		fmt.Fprintf(w, "\n## %s\n", section.Title)
+		slot := ""
+		for n, e := range section.Entries {
+			if e.Slot != slot {
+				fmt.Fprintf(w, "\n### %s\n\n", e.Slot)
+				slot = e.Slot
+			} else if n == 0 {
+				fmt.Fprintln(w)
+			}
+			text := e.Text
+			if e.struck() {
+				text = "~~" + text + "~~"
+			}
+			// Continuation lines stay in the item, each on a line of its own.
+			text = strings.ReplaceAll(text, "\n", "  \n  ")
+			fmt.Fprintf(w, "- %s %s\n", renderMarker(e.Marker, true), text)
+		}
+	}
+	return nil
+}
+
+const htmlStyle = `body { font-family: -apple-system, "Segoe UI", Helvetica, sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; color: #222; line-height: 1.5; }
+h2 { border-bottom: 1px solid #ddd; padding-bottom: .2em; margin-top: 1.5em; }
+h3 { color: #666; font-size: 1em; }
+ul { list-style: none; padding-left: 0; }
+li .marker { display: inline-block; width: 1.5em; color: #888; }
+li.done, li.cancelled, li.migrated { color: #888; }`
+
+// exportHTML writes the log as a standalone HTML page, laid out like
+// exportMarkdown.
+func exportHTML(w io.Writer, sections []exportSection) error {
+	fmt.Fprintln(w, "<!DOCTYPE html>")
+	fmt.Fprintln(w, `<html><head><meta charset="utf-8"><title>Bullet log</title>`)
+	fmt.Fprintf(w, "<style>\n%s\n</style></head><body>\n<h1>Bullet log</h1>\n", htmlStyle)
+	for _, section := range sections {
+		fmt.Fprintf(w, "<h2>%s</h2>\n", html.EscapeString(section.Title))
+		slot := ""
+		open := false
+		for _, e := range section.Entries {
+			if e.Slot != slot {
+				if open {
+					fmt.Fprintln(w, "</ul>")
+					open = false
+				}
+				fmt.Fprintf(w, "<h3>%s</h3>\n", e.Slot)
+				slot = e.Slot
+			}
+			if !open {
+				fmt.Fprintln(w, "<ul>")
+				open = true
+			}
+			class := e.Type
+			if e.Type == "task" {
+				class = e.Status
+			}
+			text := strings.ReplaceAll(html.EscapeString(e.Text), "\n", "<br>")
+			if e.struck() {
+				text = "<s>" + text + "</s>"
+			}
+			fmt.Fprintf(w, "<li class=\"%s\"><span class=\"marker\">%s</span>%s</li>\n", class, renderMarker(e.Marker, true), text)
+		}
+		if open {
+			fmt.Fprintln(w, "</ul>")
+		}
+	}
+	fmt.Fprintln(w, "</body></html>")
+	return nil
+}
+
+// exportCSV writes a row per entry with its date, type, status, text, tags
+// separated by spaces, and ID. Entries of undated sections have no date.
+func exportCSV(w io.Writer, sections []exportSection) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "type", "status", "text", "tags", "id"})
+	for _, section := range sections {
+		for _, e := range section.Entries {
+			id := ""
+			if e.ID > 0 {
+				id = strconv.Itoa(e.ID)
+			}
+			out.Write([]string{e.Date, e.Type, e.Status, e.Text, strings.Join(e.Tags, " "), id})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportICS writes an iCalendar feed with an all-day event for each task with
+// a due date, on that date, and for each event entry, on its section's date.
+// Events under a `### HH:MM` sub-section last an hour from that time instead.
+// UIDs come from entry IDs, so importing a later export updates the events
+// instead of duplicating them. Migrated copies of tasks are left out.
+func exportICS(w io.Writer, sections []exportSection) error {
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	writeICSLine(w, "BEGIN:VCALENDAR")
+	writeICSLine(w, "VERSION:2.0")
+	writeICSLine(w, "PRODID:-//thara//blt//EN")
+	for _, section := range sections {
+		for _, e := range section.Entries {
+			var start string
+			summary := e.Text
+			switch {
+			case e.Type == "task" && e.Due != "" && e.Status != "migrated":
+				start = "DTSTART;VALUE=DATE:" + e.Due
+				summary = strings.Join(strings.Fields(duePattern.ReplaceAllString(summary, " ")), " ")
+				if e.Status == "done" {
+					summary = "✓ " + summary
+				}
+			case e.Type == "event" && e.Date != "" && e.Slot != "":
+				start = fmt.Sprintf("DTSTART:%sT%s00", e.Date, strings.Replace(e.Slot, ":", "", 1))
+			case e.Type == "event" && e.Date != "":
+				start = "DTSTART;VALUE=DATE:" + e.Date
+			default:
+				continue
+			}
+
+			writeICSLine(w, "BEGIN:VEVENT")
+			writeICSLine(w, "UID:"+icsUID(e))
+			writeICSLine(w, "DTSTAMP:"+stamp)
+			writeICSLine(w, start)
+			if e.Type == "event" && e.Slot != "" {
+				writeICSLine(w, "DURATION:PT1H")
+			}
+			writeICSLine(w, "SUMMARY:"+icsEscape(summary))
+			if len(e.Tags) > 0 {
+				writeICSLine(w, "CATEGORIES:"+icsEscape(strings.Join(e.Tags, ",")))
+			}
+			if e.Status == "cancelled" {
+				writeICSLine(w, "STATUS:CANCELLED")
+			}
+			writeICSLine(w, "END:VEVENT")
+		}
+	}
+	writeICSLine(w, "END:VCALENDAR")
+	return nil
+}
+
+// icsUID returns the UID of an entry's event: from its ID, or for an entry
+// without one, from its date and text.
+func icsUID(e exportEntry) string {
+	if e.ID > 0 {
+		return fmt.Sprintf("%d@blt", e.ID)
+	}
+	return fmt.Sprintf("%x@blt", sha1.Sum([]byte(e.Date+" "+e.Text)))
+}
+
+// icsEscape escapes text for an iCalendar property value.
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// writeICSLine writes an iCalendar content line, folded into lines of at most
+// 75 bytes without splitting a character.
+func writeICSLine(w io.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		fmt.Fprintf(w, "%s\r\n ", line[:i])
+		line = line[i:]
+		// Continuation lines start with a space.
+		limit = 74
+	}
+	fmt.Fprintf(w, "%s\r\n", line)
+}
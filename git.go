@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// autoCommit reports whether changes to the log are committed to git, as set
+// with --commit or `autocommit = true` under [git] in config.toml.
+func autoCommit(c *cli.Context) bool {
+	if c != nil && c.Bool("commit") {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(getSetting("BULLETLOG_GIT_AUTOCOMMIT"))
+	return enabled
+}
+
+// runGit runs git in dir and returns its output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", ioError(fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(out))))
+	}
+	return string(out), nil
+}
+
+// commitLog commits the log at path to the git repository holding it, with
+// message as the commit message. Nothing happens when the log is unchanged.
+func commitLog(path string, message string) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	names, err := commitNames(dir, name, path)
+	if err != nil {
+		return err
+	}
+	if _, err := runGit(dir, append([]string{"add", "--"}, names...)...); err != nil {
+		return err
+	}
+	status, err := runGit(dir, append([]string{"status", "--porcelain", "--"}, names...)...)
+	if err != nil || status == "" {
+		return err
+	}
+	_, err = runGit(dir, append([]string{"commit", "--quiet", "-m", message, "--"}, names...)...)
+	return err
+}
+
+// commitNames returns the names in dir of the files the log at path is kept
+// in, with those of the months a split log no longer has that git still
+// tracks, so that their removal is committed too.
+func commitNames(dir string, name string, path string) ([]string, error) {
+	files, err := logFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	if len(files) == 1 {
+		return names, nil
+	}
+	deleted, err := runGit(dir, "ls-files", "--deleted", "--", name+".*")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range strings.Fields(deleted) {
+		if _, ok := monthOf(name, file); ok {
+			names = append(names, file)
+		}
+	}
+	return names, nil
+}
+
+// describeChange summarizes how the log went from before to after for a
+// commit message, by the text of the first entry added or changed, or else
+// removed, such as "buy milk (and 2 more)".
+func describeChange(before []string, after []string) string {
+	count := func(lines []string) map[string]int {
+		counts := map[string]int{}
+		for _, line := range lines {
+			if isBullet(line) {
+				counts[line]++
+			}
+		}
+		return counts
+	}
+	changed := func(from []string, to map[string]int) []string {
+		var lines []string
+		for _, line := range from {
+			if !isBullet(line) {
+				continue
+			}
+			if to[line] > 0 {
+				to[line]--
+				continue
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	lines := changed(after, count(before))
+	if len(lines) == 0 {
+		lines = changed(before, count(after))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	first, _ := splitEntry(lines[0])
+	summary := strings.TrimSpace(idPattern.ReplaceAllString(taskText(first), ""))
+	if len(lines) > 1 {
+		summary = fmt.Sprintf("%s (and %d more)", summary, len(lines)-1)
+	}
+	return summary
+}
+
+// commitChange commits a change made by command to the log at path, when
+// auto-commit is on.
+func commitChange(c *cli.Context, path string, command string, before []string, after []string) error {
+	if !autoCommit(c) {
+		return nil
+	}
+	message := command
+	if summary := describeChange(before, after); summary != "" {
+		message = fmt.Sprintf("%s: %s", command, summary)
+	}
+	return commitLog(path, message)
+}
+
+// syncLog commits any uncommitted change to the log, then pulls, rebasing on
+// the remote's history, and pushes. With a remote log set, it merges with that
+// log instead; see syncWithRemote.
+func syncLog(c *cli.Context) error {
+	if remote := getSyncRemote(c); remote != "" {
+		return syncWithRemote(c, remote)
+	}
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	if err := commitLog(path, "sync"); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for _, args := range [][]string{{"pull", "--rebase", "--quiet"}, {"push", "--quiet"}} {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return ioError(fmt.Errorf("git %s: %v", args[0], err))
+		}
+	}
+	return nil
+}
@@ -2,4 +2,12 @@ module github.com/thara/blt
 
 go 1.14
 
-require github.com/urfave/cli/v2 v2.2.0
+require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/gdamore/tcell/v2 v2.0.1-0.20201017141208-acf90d56d591
+	github.com/mattn/go-sqlite3 v1.14.5
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/rivo/tview v0.0.0-20201118063654-f007e9ad3893
+	github.com/urfave/cli/v2 v2.2.0
+	golang.org/x/sys v0.0.0-20201017003518-b09fb700fbb7
+)
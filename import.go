@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// importedEntry is an entry read by an importer, to be filed under date.
+type importedEntry struct {
+	date  time.Time
+	entry string
+}
+
+// importers read entries in the format they are named by. today is the date
+// of entries the format does not date.
+var importers = map[string]func(r io.Reader, today time.Time) ([]importedEntry, error){
+	"todotxt":     importTodoTxt,
+	"taskwarrior": importTaskwarrior,
+}
+
+func importFormats() []string {
+	formats := make([]string, 0, len(importers))
+	for format := range importers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// importLog adds the entries of a file in the format given with --format to
+// the log in a single rewrite. The file is read from stdin when it is - or
+// not given.
+func importLog(c *cli.Context) error {
+	read, ok := importers[c.String("format")]
+	if !ok {
+		return fmt.Errorf("Unknown format: %q; use one of %s", c.String("format"), strings.Join(importFormats(), ", "))
+	}
+	today, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if name := c.Args().First(); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return ioError(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	entries, err := read(in, today)
+	if err != nil {
+		return parseError(err)
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		lines, err = insertBullet(lines, e.date, "", withID(lines, e.entry))
+		if err != nil {
+			return err
+		}
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	if !c.Bool("dry-run") {
+		fmt.Printf("Imported %d entries\n", len(entries))
+	}
+	return nil
+}
+
+// mergeLog merges another bullet log into the log, for when entries went to
+// two logs by mistake: its sections are filed in by date, leaving out the
+// entries the log already has, as mergeSections does.
+func mergeLog(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return errors.New("Usage: blt merge FILE")
+	}
+	other, err := readLines(name)
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	merged, added, err := mergeSections(lines, other)
+	if err != nil {
+		return err
+	}
+	if strings.Join(merged, "\n") == strings.Join(lines, "\n") {
+		fmt.Printf("Nothing to merge from %s\n", name)
+		return nil
+	}
+	if err := log.Save(merged); err != nil {
+		return err
+	}
+	if !c.Bool("dry-run") {
+		fmt.Printf("Merged %d entries from %s\n", added, name)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+)
+
+// The search index is kept next to the log when BULLETLOG_SEARCH_INDEX is
+// true. It holds every entry with its section, and for each trigram of the
+// entries' lowercased lines the entries containing it, so that search reads
+// the index rather than the whole log and looks only at the entries that can
+// match. Every change blt records updates it in place.
+func indexPath(path string) string { return path + ".index" }
+
+func searchIndexEnabled() bool {
+	enabled, _ := strconv.ParseBool(getSetting("BULLETLOG_SEARCH_INDEX"))
+	return enabled
+}
+
+type indexedEntry struct {
+	Line   string
+	Header string
+	// Date is the date of the section, "" for the undated sections.
+	Date string
+}
+
+type searchIndex struct {
+	// Sum is the SHA-1 of the lines indexed, and Stamp the size and
+	// modification time of the log when the index was written, to tell when
+	// the log changed since.
+	Sum     [sha1.Size]byte
+	Stamp   string
+	Entries map[int]indexedEntry
+	// Grams maps a trigram to the indexes of the lines containing it.
+	Grams map[string][]int
+}
+
+func logSum(lines []string) [sha1.Size]byte {
+	return sha1.Sum([]byte(strings.Join(lines, "\n")))
+}
+
+func logStamp(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// trigrams returns the distinct sequences of three characters in text,
+// lowercased.
+func trigrams(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	seen := map[string]bool{}
+	var grams []string
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// sectionAt returns the header and date of the section holding line i.
+func sectionAt(lines []string, i int) (string, string) {
+	for ; i >= 0; i-- {
+		if bulletlog.IsHeader(lines[i]) {
+			date := ""
+			if t := sectionDate(lines, i); t != nil {
+				date = t.Format(dateFormat)
+			}
+			return lines[i], date
+		}
+	}
+	return "", ""
+}
+
+func (idx *searchIndex) add(i int, e indexedEntry) {
+	idx.Entries[i] = e
+	for _, gram := range trigrams(e.Line) {
+		idx.Grams[gram] = append(idx.Grams[gram], i)
+	}
+}
+
+// index indexes the entries from line start up to end, and gives the entries
+// after end up to the next header the section they are in.
+func (idx *searchIndex) index(lines []string, start int, end int) {
+	header, date := sectionAt(lines, start-1)
+	for i := start; i < len(lines); i++ {
+		if bulletlog.IsHeader(lines[i]) {
+			if i >= end {
+				break
+			}
+			header, date = sectionAt(lines, i)
+			continue
+		}
+		if i < end {
+			if isBullet(lines[i]) {
+				idx.add(i, indexedEntry{lines[i], header, date})
+			}
+		} else if e, ok := idx.Entries[i]; ok {
+			e.Header, e.Date = header, date
+			idx.Entries[i] = e
+		}
+	}
+}
+
+// lineNumbers returns the line of the file each entry indexed starts on, as
+// bulletlog.LineNumbers. Only entries hold continuation lines, so those
+// before an entry tell how far its line is from its index.
+func (idx *searchIndex) lineNumbers() map[int]int {
+	indexes := make([]int, 0, len(idx.Entries))
+	for i := range idx.Entries {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	numbers := make(map[int]int, len(indexes))
+	continued := 0
+	for _, i := range indexes {
+		numbers[i] = i + 1 + continued
+		continued += strings.Count(idx.Entries[i].Line, "\n")
+	}
+	return numbers
+}
+
+func buildIndex(lines []string) *searchIndex {
+	idx := &searchIndex{
+		Sum:     logSum(lines),
+		Entries: map[int]indexedEntry{},
+		Grams:   map[string][]int{},
+	}
+	idx.index(lines, 0, len(lines))
+	return idx
+}
+
+// apply updates the index for a change to the log, which now holds lines.
+func (idx *searchIndex) apply(ch change, lines []string) {
+	end := ch.Start + len(ch.Removed)
+	shift := len(ch.Added) - len(ch.Removed)
+	move := func(i int) (int, bool) {
+		switch {
+		case i < ch.Start:
+			return i, true
+		case i >= end:
+			return i + shift, true
+		}
+		return 0, false
+	}
+
+	entries := make(map[int]indexedEntry, len(idx.Entries))
+	for i, e := range idx.Entries {
+		if j, ok := move(i); ok {
+			entries[j] = e
+		}
+	}
+	idx.Entries = entries
+	for gram, indexes := range idx.Grams {
+		kept := indexes[:0]
+		for _, i := range indexes {
+			if j, ok := move(i); ok {
+				kept = append(kept, j)
+			}
+		}
+		if len(kept) == 0 {
+			delete(idx.Grams, gram)
+		} else {
+			idx.Grams[gram] = kept
+		}
+	}
+	idx.index(lines, ch.Start, ch.Start+len(ch.Added))
+	idx.Sum = logSum(lines)
+}
+
+// lookup returns the indexes of the lines containing every term, in the
+// order of the log. Terms shorter than a trigram narrow nothing.
+func (idx *searchIndex) lookup(terms []string) []int {
+	var candidates map[int]bool
+	for _, term := range terms {
+		for _, gram := range trigrams(term) {
+			next := map[int]bool{}
+			for _, i := range idx.Grams[gram] {
+				if candidates == nil || candidates[i] {
+					next[i] = true
+				}
+			}
+			candidates = next
+		}
+	}
+
+	var indexes []int
+	if candidates == nil {
+		for i := range idx.Entries {
+			indexes = append(indexes, i)
+		}
+	} else {
+		for i := range candidates {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func readIndex(path string) (*searchIndex, error) {
+	f, err := os.Open(indexPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ioError(err)
+	}
+	defer f.Close()
+
+	var idx searchIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		// A broken index is rebuilt like a missing one.
+		return nil, nil
+	}
+	return &idx, nil
+}
+
+func writeIndex(path string, idx *searchIndex) error {
+	stamp, err := logStamp(path)
+	if err != nil {
+		return ioError(err)
+	}
+	idx.Stamp = stamp
+	return writeFile(indexPath(path), func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(idx)
+	})
+}
+
+// updateIndex updates the search index, when there is one, for a change to
+// the log at path from before to after. An index of something other than
+// before is rebuilt.
+func updateIndex(path string, before []string, after []string) error {
+	if !searchIndexEnabled() {
+		return nil
+	}
+	idx, err := readIndex(path)
+	if err != nil {
+		return err
+	}
+	if idx == nil || idx.Sum != logSum(before) {
+		idx = buildIndex(after)
+	} else {
+		idx.apply(newChange("", before, after), after)
+	}
+	return writeIndex(path, idx)
+}
+
+// searchIndexed finds the entries match accepts through the search index of
+// the log at path, rebuilding the index from the lines load returns when the
+// log changed outside blt.
+func searchIndexed(path string, load func() ([]string, error), terms []string, match func(line string) bool) ([]foundEntry, error) {
+	idx, err := readIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	stamp, err := logStamp(path)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	if idx == nil || idx.Stamp != stamp {
+		lines, err := load()
+		if err != nil {
+			return nil, err
+		}
+		idx = buildIndex(lines)
+		if err := writeIndex(path, idx); err != nil {
+			return nil, err
+		}
+	}
+
+	numbers := idx.lineNumbers()
+	var found []foundEntry
+	for _, i := range idx.lookup(terms) {
+		e := idx.Entries[i]
+		if !match(e.Line) {
+			continue
+		}
+		f := foundEntry{Index: i, Number: numbers[i], Line: e.Line, Header: e.Header}
+		if e.Date != "" {
+			if t, err := time.Parse(dateFormat, e.Date); err == nil {
+				f.Date = &t
+			}
+		}
+		found = append(found, f)
+	}
+	return found, nil
+}
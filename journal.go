@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+	"github.com/urfave/cli/v2"
+)
+
+// maxJournal is how many changes can be undone.
+const maxJournal = 100
+
+// change is a change to the log as the journal keeps it: the lines removed
+// from and added at Start, with the lines around them left out.
+type change struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Start   int       `json:"start"`
+	Removed []string  `json:"removed,omitempty"`
+	Added   []string  `json:"added,omitempty"`
+}
+
+// newChange returns the change that turns before into after.
+func newChange(command string, before []string, after []string) change {
+	start := 0
+	for start < len(before) && start < len(after) && before[start] == after[start] {
+		start++
+	}
+	end := 0
+	for end < len(before)-start && end < len(after)-start && before[len(before)-1-end] == after[len(after)-1-end] {
+		end++
+	}
+	return change{
+		Time:    time.Now(),
+		Command: command,
+		Start:   start,
+		Removed: append([]string(nil), before[start:len(before)-end]...),
+		Added:   append([]string(nil), after[start:len(after)-end]...),
+	}
+}
+
+// apply makes the change to lines, or with reverse takes it back. It fails
+// when the lines to replace are not what the change expects, which means
+// the log was changed some other way since.
+func (ch change) apply(lines []string, reverse bool) ([]string, error) {
+	from, to := ch.Removed, ch.Added
+	if reverse {
+		from, to = to, from
+	}
+	if ch.Start+len(from) > len(lines) {
+		return nil, errors.New("The log changed since; cannot replay the change")
+	}
+	for i, line := range from {
+		if lines[ch.Start+i] != line {
+			return nil, errors.New("The log changed since; cannot replay the change")
+		}
+	}
+	result := make([]string, 0, len(lines)-len(from)+len(to))
+	result = append(result, lines[:ch.Start]...)
+	result = append(result, to...)
+	return append(result, lines[ch.Start+len(from):]...), nil
+}
+
+func (ch change) String() string {
+	summary := describeChange(ch.Removed, ch.Added)
+	if summary == "" {
+		return ch.Command
+	}
+	return fmt.Sprintf("%s: %s", ch.Command, summary)
+}
+
+// The journal of changes that can be undone, and those undone that can be
+// redone, are kept next to the log, one change per line.
+func journalPath(path string) string { return path + ".journal" }
+func redoPath(path string) string    { return path + ".redo" }
+
+func readChanges(path string) ([]change, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ioError(err)
+	}
+	defer f.Close()
+
+	var changes []change
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var ch change
+		if err := json.Unmarshal(scanner.Bytes(), &ch); err != nil {
+			return nil, parseError(fmt.Errorf("%s:%d: %v", path, n, err))
+		}
+		changes = append(changes, ch)
+	}
+	return changes, ioError(scanner.Err())
+}
+
+func writeChanges(path string, changes []change) error {
+	if len(changes) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return ioError(err)
+		}
+		return nil
+	}
+	lines := make([]string, len(changes))
+	for i, ch := range changes {
+		b, err := json.Marshal(ch)
+		if err != nil {
+			return err
+		}
+		lines[i] = string(b)
+	}
+	return writeLines(path, lines)
+}
+
+// journalChange records a change to the log at path for undo. A new change
+// can no longer be redone after.
+func journalChange(path string, ch change) error {
+	changes, err := readChanges(journalPath(path))
+	if err != nil {
+		return err
+	}
+	changes = append(changes, ch)
+	if len(changes) > maxJournal {
+		changes = changes[len(changes)-maxJournal:]
+	}
+	if err := writeChanges(journalPath(path), changes); err != nil {
+		return err
+	}
+	return writeChanges(redoPath(path), nil)
+}
+
+// recordChange records that command changed the log at path from before to
+// after: in the journal, the history, and git when auto-commit is on.
+func recordChange(c *cli.Context, path string, command string, before []string, after []string) error {
+	if strings.Join(before, "\n") == strings.Join(after, "\n") {
+		return nil
+	}
+	ch := newChange(command, before, after)
+	if err := journalChange(path, ch); err != nil {
+		return err
+	}
+	if err := appendHistory(path, ch, before); err != nil {
+		return err
+	}
+	if err := updateIndex(path, before, after); err != nil {
+		return err
+	}
+	if err := updateStamps(path, before, after, ch.Time); err != nil {
+		return err
+	}
+	return commitChange(c, path, command, before, after)
+}
+
+// saveChange writes after to the log at path, which held before, and records
+// the change. The log is backed up first when backups are on.
+func saveChange(c *cli.Context, path string, command string, before []string, after []string) error {
+	if strings.Join(before, "\n") != strings.Join(after, "\n") {
+		if err := autoBackup(path); err != nil {
+			return err
+		}
+	}
+	if err := writeLog(path, after); err != nil {
+		return err
+	}
+	return recordChange(c, path, command, before, after)
+}
+
+func undo(c *cli.Context) error {
+	return replay(c, journalPath, redoPath, true)
+}
+
+func redo(c *cli.Context) error {
+	return replay(c, redoPath, journalPath, false)
+}
+
+// replay takes the last change off the list at from, applies it to the log,
+// reversed for undo, and puts it on the list at to.
+func replay(c *cli.Context, from func(string) string, to func(string) string, reverse bool) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	changes, err := readChanges(from(path))
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		if reverse {
+			return errors.New("Nothing to undo")
+		}
+		return errors.New("Nothing to redo")
+	}
+	ch := changes[len(changes)-1]
+
+	before, err := readLog(path)
+	if err != nil {
+		return err
+	}
+	after, err := ch.apply(before, reverse)
+	if err != nil {
+		return err
+	}
+	if c.Bool("dry-run") {
+		return saveLines(c, path, after)
+	}
+	if err := autoBackup(path); err != nil {
+		return err
+	}
+	if err := writeLog(path, after); err != nil {
+		return err
+	}
+
+	done, err := readChanges(to(path))
+	if err != nil {
+		return err
+	}
+	if err := writeChanges(to(path), append(done, ch)); err != nil {
+		return err
+	}
+	if err := writeChanges(from(path), changes[:len(changes)-1]); err != nil {
+		return err
+	}
+
+	command, verb := "redo", "Redid"
+	if reverse {
+		command, verb = "undo", "Undid"
+	}
+	fmt.Printf("%s %s\n", verb, ch)
+	if err := appendHistory(path, newChange(command, before, after), before); err != nil {
+		return err
+	}
+	if err := updateIndex(path, before, after); err != nil {
+		return err
+	}
+	if err := updateStamps(path, before, after, time.Now()); err != nil {
+		return err
+	}
+	return commitChange(c, path, command, before, after)
+}
+
+// historyEntry is a line of the history: when a command changed the log, and
+// the entry it changed.
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Entry   string    `json:"entry,omitempty"`
+	// Line is the first line changed, counting from 1.
+	Line int `json:"line"`
+}
+
+// The history of changes is kept next to the log and only ever appended to.
+func historyPath(path string) string { return path + ".history" }
+
+// appendHistory adds ch, made to the lines before, to the history.
+func appendHistory(path string, ch change, before []string) error {
+	b, err := json.Marshal(historyEntry{
+		Time:    ch.Time,
+		Command: ch.Command,
+		Entry:   describeChange(ch.Removed, ch.Added),
+		Line:    bulletlog.LineNumber(before, ch.Start),
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return ioError(err)
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", b); err != nil {
+		f.Close()
+		return ioError(err)
+	}
+	return ioError(f.Close())
+}
+
+// showHistory prints the changes made to the log, oldest first, or the last
+// --last of them.
+func showHistory(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	entries := []historyEntry{}
+	f, err := os.Open(historyPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return ioError(err)
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			var e historyEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				return parseError(fmt.Errorf("%s:%d: %v", historyPath(path), n, err))
+			}
+			entries = append(entries, e)
+		}
+		if err := scanner.Err(); err != nil {
+			return ioError(err)
+		}
+	}
+	if last := c.Int("last"); last > 0 && last < len(entries) {
+		entries = entries[len(entries)-last:]
+	}
+
+	if wantJSON(c) {
+		return writeJSON(out, entries)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\tline %d\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Command, e.Line, e.Entry)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout is how long blt waits for another blt to let go of the log.
+const lockTimeout = 5 * time.Second
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// The lock on a log is taken on a file next to it, since the log itself is
+// replaced on every rewrite.
+func lockPath(path string) string { return path + ".lock" }
+
+// lockLog takes the lock on the log at path, waiting up to lockTimeout for
+// another blt to let go of it. The returned function lets go of it.
+func lockLog(path string) (func(), error) {
+	f, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := tryLock(f)
+		if err == nil {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		if err != errLocked {
+			f.Close()
+			return nil, ioError(err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, ioError(fmt.Errorf("%s is in use by another blt; gave up after waiting %s", path, lockTimeout))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// heldLocks are the locks this blt holds until it exits, by log path.
+var heldLocks = map[string]func(){}
+
+// holdLock takes the lock on the log at path for the rest of the command, so
+// that what it reads is not changed by another blt before it writes.
+func holdLock(path string) error {
+	if _, ok := heldLocks[path]; ok {
+		return nil
+	}
+	unlock, err := lockLog(path)
+	if err != nil {
+		return err
+	}
+	heldLocks[path] = unlock
+	return nil
+}
+
+// releaseLock lets go of the lock held on the log at path, if any.
+func releaseLock(path string) {
+	if unlock, ok := heldLocks[path]; ok {
+		unlock()
+		delete(heldLocks, path)
+	}
+}
+
+// releaseLocks lets go of every lock held.
+func releaseLocks() {
+	for path := range heldLocks {
+		releaseLock(path)
+	}
+}
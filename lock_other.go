@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "os"
+
+// Elsewhere the log is not locked.
+
+func tryLock(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
@@ -2,283 +2,6178 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/thara/blt/pkg/bulletlog"
 	"github.com/urfave/cli/v2"
 )
 
-func getLogPath() string {
-	path, ok := os.LookupEnv("BULLETLOG_FILE")
-	if !ok {
-		path = ".BULLETLOG"
+// config is the content of config.toml. Each setting stands in for the
+// BULLETLOG_* environment variable of the same name, which overrides it.
+type config struct {
+	File           string   `toml:"file"`
+	Storage        string   `toml:"storage"`
+	TmpDir         string   `toml:"tmpdir"`
+	OpenMarkers    []string `toml:"open_markers"`
+	DoneMarker     string   `toml:"done_marker"`
+	DailyGoal      int      `toml:"daily_goal"`
+	PromptOnNewDay bool     `toml:"prompt_on_new_day"`
+	NewDayPrompt   string   `toml:"new_day_prompt"`
+	EntryTemplate  string   `toml:"entry_template"`
+	DayTemplate    string   `toml:"day_template"`
+	ArchiveDir     string   `toml:"archive_dir"`
+	Rollover       bool     `toml:"rollover"`
+	SearchIndex    bool     `toml:"search_index"`
+	EntrySpacing   *int     `toml:"entry_spacing"`
+	SectionOrder   string   `toml:"section_order"`
+	Color          string   `toml:"color"`
+	// Aliases maps an alias to the command line it runs.
+	Aliases map[string]string `toml:"aliases"`
+	// Defaults maps a command to flags it is run with unless overridden.
+	Defaults map[string]string `toml:"defaults"`
+	// Books maps a notebook name to the path of its log.
+	Books map[string]string `toml:"books"`
+	Git   struct {
+		Autocommit bool `toml:"autocommit"`
+	} `toml:"git"`
+	Sync struct {
+		// Remote is the path or URL of the log sync merges with instead of
+		// using git, and Token what it is sent to a URL with.
+		Remote string `toml:"remote"`
+		Token  string `toml:"token"`
+	} `toml:"sync"`
+	Serve struct {
+		Addr string `toml:"addr"`
+		// Token is what clients of `blt serve` must send.
+		Token string `toml:"token"`
+	} `toml:"serve"`
+	Backup struct {
+		// Keep is how many backups are kept; 0 takes none before rewrites.
+		Keep int    `toml:"keep"`
+		Dir  string `toml:"dir"`
+	} `toml:"backup"`
+}
+
+// getConfigPath returns where config.toml is read from: BULLETLOG_CONFIG, or
+// blt/config.toml under $XDG_CONFIG_HOME, falling back to ~/.config.
+func getConfigPath() (string, error) {
+	if path, ok := os.LookupEnv("BULLETLOG_CONFIG"); ok {
+		return path, nil
 	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "blt", "config.toml"), nil
+}
 
-	_, err := os.Stat(path)
+// loadConfig reads config.toml. A missing file is an empty config.
+func loadConfig() (config, error) {
+	var cfg config
+	path, err := getConfigPath()
+	if err != nil {
+		return cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
 	if os.IsNotExist(err) {
-		file, err := os.Create(path)
-		if err != nil {
-			log.Fatal(err)
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, ioError(err)
+	}
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return cfg, parseError(fmt.Errorf("%s: %v", path, err))
+	}
+	return cfg, nil
+}
+
+// settings returns the config as the environment variables it stands in for.
+func (cfg config) settings() map[string]string {
+	settings := map[string]string{}
+	set := func(name string, value string) {
+		if value != "" {
+			settings[name] = value
 		}
-		defer file.Close()
 	}
-	return path
+	set("BULLETLOG_FILE", expandHome(cfg.File))
+	set("BULLETLOG_STORAGE", cfg.Storage)
+	set("BULLETLOG_TMPDIR", expandHome(cfg.TmpDir))
+	set("BULLETLOG_OPEN_MARKERS", strings.Join(cfg.OpenMarkers, ","))
+	set("BULLETLOG_DONE_MARKER", cfg.DoneMarker)
+	if cfg.DailyGoal > 0 {
+		set("BULLETLOG_DAILY_GOAL", strconv.Itoa(cfg.DailyGoal))
+	}
+	if cfg.PromptOnNewDay {
+		set("BULLETLOG_PROMPT_ON_NEW_DAY", "true")
+	}
+	set("BULLETLOG_NEW_DAY_PROMPT", cfg.NewDayPrompt)
+	set("BULLETLOG_ENTRY_TEMPLATE", expandHome(cfg.EntryTemplate))
+	set("BULLETLOG_DAY_TEMPLATE", expandHome(cfg.DayTemplate))
+	if cfg.Rollover {
+		set("BULLETLOG_ROLLOVER", "true")
+	}
+	if cfg.SearchIndex {
+		set("BULLETLOG_SEARCH_INDEX", "true")
+	}
+	if cfg.Git.Autocommit {
+		set("BULLETLOG_GIT_AUTOCOMMIT", "true")
+	}
+	if cfg.Backup.Keep > 0 {
+		set("BULLETLOG_BACKUPS", strconv.Itoa(cfg.Backup.Keep))
+	}
+	set("BULLETLOG_BACKUP_DIR", expandHome(cfg.Backup.Dir))
+	set("BULLETLOG_ARCHIVE_DIR", expandHome(cfg.ArchiveDir))
+	set("BULLETLOG_SYNC_REMOTE", cfg.Sync.Remote)
+	set("BULLETLOG_SYNC_TOKEN", cfg.Sync.Token)
+	set("BULLETLOG_SERVE_ADDR", cfg.Serve.Addr)
+	set("BULLETLOG_SERVE_TOKEN", cfg.Serve.Token)
+	if cfg.EntrySpacing != nil {
+		set("BULLETLOG_ENTRY_SPACING", strconv.Itoa(*cfg.EntrySpacing))
+	}
+	set("BULLETLOG_SECTION_ORDER", cfg.SectionOrder)
+	set("BULLETLOG_COLOR", cfg.Color)
+
+	names := make([]string, 0, len(cfg.Aliases))
+	for name := range cfg.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var aliases []string
+	for _, name := range names {
+		aliases = append(aliases, fmt.Sprintf("%s=%s", name, cfg.Aliases[name]))
+	}
+	set("BULLETLOG_ALIASES", strings.Join(aliases, ";"))
+	return settings
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
 }
 
-const dateFormat = "20060102"
+var cfg, cfgErr = loadConfig()
 
-func getDate() (time.Time, error) {
-	date, ok := os.LookupEnv("BULLETLOG_DATE")
-	if ok {
-		return time.Parse(dateFormat, date)
+var configSettings = cfg.settings()
+
+// lookupSetting returns a BULLETLOG_* setting from the environment or, if it
+// is not set there, from config.toml.
+func lookupSetting(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
 	}
-	return time.Now().Truncate(24 * time.Hour), nil
+	value, ok := configSettings[name]
+	return value, ok
+}
+
+// getSetting is lookupSetting for settings where empty means unset.
+func getSetting(name string) string {
+	value, _ := lookupSetting(name)
+	return value
 }
 
-func getDateFromHeader(line string) (*time.Time, error) {
-	if !strings.HasPrefix(line, "##") {
-		return nil, errors.New("The prefix must be ##")
+// withDefaults inserts the default flags config.toml gives for the command in
+// args right after its name, so that flags given on the command line come
+// later and win.
+func withDefaults(app *cli.App, args []string, defaults map[string]string) []string {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			if !strings.Contains(arg, "=") && takesValue(app.Flags, arg) {
+				i++
+			}
+			continue
+		}
+
+		cmd := app.Command(arg)
+		if cmd == nil || defaults[cmd.Name] == "" {
+			break
+		}
+		result := append([]string{}, args[:i+1]...)
+		result = append(result, strings.Fields(defaults[cmd.Name])...)
+		return append(result, args[i+1:]...)
 	}
-	f := strings.Fields(line)
-	if len(f) != 2 {
-		return nil, errors.New("Invalid header notion")
+	return args
+}
+
+// takesValue reports whether arg names one of flags that is given a value.
+func takesValue(flags []cli.Flag, arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	for _, f := range flags {
+		for _, n := range f.Names() {
+			if n != name {
+				continue
+			}
+			_, isBool := f.(*cli.BoolFlag)
+			return !isBool
+		}
 	}
-	dateStr := f[1]
-	t, err := time.Parse(dateFormat, dateStr)
-	t = t.Truncate(24 * time.Hour)
-	return &t, err
+	return false
 }
 
-func addNote(c *cli.Context) error {
-	return addBullet(c, "*")
+// isFlag reports whether arg names one of flags, as `--name`, `-n` or
+// `--name=value`.
+func isFlag(flags []cli.Flag, arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+	for _, f := range flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func addTask(c *cli.Context) error {
-	return addBullet(c, "-")
+// parseTrailingFlags sets the flags of the command left among its arguments,
+// those given after the first one, and returns the other arguments.
+func parseTrailingFlags(c *cli.Context) ([]string, error) {
+	var args []string
+	rest := c.Args().Slice()
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		if arg == "--" {
+			return append(args, rest[i+1:]...), nil
+		}
+		if !isFlag(c.Command.Flags, arg) {
+			args = append(args, arg)
+			continue
+		}
+		f := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)
+		name := f[0]
+		value := "true"
+		switch {
+		case len(f) == 2:
+			value = f[1]
+		case !isBoolFlag(c.Command.Flags, name):
+			if i+1 >= len(rest) {
+				return nil, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			value = rest[i]
+		}
+		if err := c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("Invalid value %q for %s: %v", value, arg, err)
+		}
+	}
+	return args, nil
 }
 
-func addBullet(c *cli.Context, mark string) error {
-	note := c.Args().First()
+func isBoolFlag(flags []cli.Flag, name string) bool {
+	for _, f := range flags {
+		if b, ok := f.(*cli.BoolFlag); ok {
+			for _, n := range b.Names() {
+				if n == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
 
-	entry := fmt.Sprintf("%s %s", mark, note)
+func listBooks(c *cli.Context) error {
+	names := make([]string, 0, len(cfg.Books))
+	for name := range cfg.Books {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	path := getLogPath()
-	date, err := getDate()
-	if err != nil {
-		log.Fatal(err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, cfg.Books[name])
 	}
-	dateStr := date.Format(dateFormat)
+	return w.Flush()
+}
 
-	file, err := os.Open(path)
+var bookNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// addBook adds a notebook to the [books] table of config.toml. The file is
+// edited as text, so comments and layout elsewhere are kept.
+func addBook(c *cli.Context) error {
+	name, path := c.Args().Get(0), c.Args().Get(1)
+	if !bookNamePattern.MatchString(name) || path == "" {
+		return errors.New("Usage: blt books add NAME PATH")
+	}
+	if _, ok := cfg.Books[name]; ok {
+		return fmt.Errorf("Notebook %s already exists", name)
+	}
+
+	configPath, lines, err := readConfigLines()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	entry := fmt.Sprintf("%s = %q", name, path)
+	if i := findConfigTable(lines, "books"); i >= 0 {
+		lines = append(lines[:i+1], append([]string{entry}, lines[i+1:]...)...)
+	} else {
+		if len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "[books]", entry)
+	}
+	return writeConfigLines(configPath, lines)
+}
+
+// removeBook removes a notebook from config.toml. Its log is left alone.
+func removeBook(c *cli.Context) error {
+	name := c.Args().First()
+	if _, ok := cfg.Books[name]; !ok {
+		return fmt.Errorf("No such notebook: %s", name)
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	configPath, lines, err := readConfigLines()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	key := regexp.MustCompile(`^\s*"?` + regexp.QuoteMeta(name) + `"?\s*=`)
+	for i := findConfigTable(lines, "books") + 1; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "[") {
+			break
+		}
+		if key.MatchString(lines[i]) {
+			lines = append(lines[:i], lines[i+1:]...)
+			break
+		}
 	}
+	return writeConfigLines(configPath, lines)
+}
 
-	tmpfile, err := ioutil.TempFile("", ".BULLETLOG.*")
+func readConfigLines() (string, []string, error) {
+	path, err := getConfigPath()
 	if err != nil {
-		log.Fatal(err)
+		return "", nil, err
 	}
-	defer os.Remove(tmpfile.Name())
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path, nil, nil
+	}
+	lines, err := readLines(path)
+	return path, lines, err
+}
 
-	if fileInfo.Size() == 0 {
-		fmt.Fprintf(tmpfile, "## %s\n\n%s\n\n", dateStr, entry)
-	} else {
-		reader := bufio.NewReader(file)
+func writeConfigLines(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return ioError(err)
+	}
+	return writeLines(path, lines)
+}
 
-		firstLine := true
-		appended := false
-		for {
-			line, err := reader.ReadString('\n')
+// findConfigTable returns the index of the [name] header in the lines of
+// config.toml, or -1.
+func findConfigTable(lines []string, name string) int {
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "["+name+"]" {
+			return i
+		}
+	}
+	return -1
+}
 
-			if firstLine {
-				latest, err := getDateFromHeader(line)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if date.After(*latest) {
-					// New section
-					fmt.Fprintf(tmpfile, "## %s\n\n%s\n", dateStr, entry)
-					appended = true
-				}
-				firstLine = false
-			} else if !appended {
-				t, err := getDateFromHeader(line)
-				if err == nil {
-					// Add an entry
-					if date.After(*t) {
-						fmt.Fprintf(tmpfile, "%s\n\n", entry)
-					}
-					appended = true
-				}
-			}
+// book is the notebook chosen with --book, if any.
+var book string
 
-			fmt.Fprintf(tmpfile, line)
-			if err != nil {
-				break
-			}
-		}
-		if !appended {
-			fmt.Fprintf(tmpfile, "%s\n\n", entry)
+// logName is the name of a project-local log.
+const logName = ".BULLETLOG"
+
+// getLogPath returns the log to work on, creating it if needed: the notebook
+// chosen with --book, BULLETLOG_FILE, the nearest .BULLETLOG in the current
+// directory or one of its parents, the file set in config.toml, or else a new
+// .BULLETLOG in the current directory. The log is locked until blt exits.
+func getLogPath() (string, error) {
+	path, ok := os.LookupEnv("BULLETLOG_FILE")
+	if !ok {
+		path, ok = findLog()
+	}
+	if !ok {
+		path, ok = configSettings["BULLETLOG_FILE"]
+	}
+	if !ok {
+		path = logName
+	}
+	if book != "" {
+		p, ok := cfg.Books[book]
+		if !ok {
+			return "", fmt.Errorf("No such notebook: %s", book)
 		}
+		path = expandHome(p)
+	}
+	if err := holdLock(path); err != nil {
+		return "", err
+	}
 
-		if err != nil && err != io.EOF {
-			log.Fatal(err)
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		file, err := os.Create(path)
+		if err != nil {
+			return "", ioError(err)
 		}
+		defer file.Close()
+	}
+	return path, nil
+}
 
+// findLog looks for a .BULLETLOG in the current directory and then in each
+// parent, the way git finds .git.
+func findLog() (string, bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	for {
+		path := filepath.Join(dir, logName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
 	}
-	os.Rename(tmpfile.Name(), path)
+}
 
+// initLog creates an empty .BULLETLOG in the current directory, which every
+// command run below it then uses.
+func initLog(c *cli.Context) error {
+	if _, err := os.Stat(logName); err == nil {
+		return fmt.Errorf("%s already exists", logName)
+	}
+	file, err := os.OpenFile(logName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return ioError(err)
+	}
+	if err := file.Close(); err != nil {
+		return ioError(err)
+	}
+	path, err := filepath.Abs(logName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s\n", path)
 	return nil
 }
 
-func listNotes(c *cli.Context) error {
-	mark := "* "
+// Exit statuses. Errors not marked by ioError or parseError are usage errors.
+// exitNoMatch is search finding nothing, as grep does.
+const (
+	exitNoMatch = 1
+	exitIO      = 2
+	exitParse   = 3
+	exitUsage   = 4
+)
 
-	path := getLogPath()
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatal(err)
+// ioError marks err as a failure to read or write a file.
+func ioError(err error) error {
+	if err == nil {
+		return nil
 	}
-	defer file.Close()
+	return cli.Exit(err, exitIO)
+}
 
-	reader := bufio.NewReader(file)
+// parseError marks err as a malformed log.
+func parseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	// An error that already has its exit code, such as one reading a file
+	// along the way, keeps it.
+	if _, ok := err.(cli.ExitCoder); ok {
+		return err
+	}
+	return cli.Exit(err, exitParse)
+}
 
-	for {
-		line, err := reader.ReadString('\n')
+// getTmpDir returns the directory in which the log is rewritten before being
+// renamed over the original.
+//
+// BULLETLOG_TMPDIR takes precedence when set. Otherwise the log's own directory
+// is used: os.Rename is only atomic within a single filesystem, and fails
+// outright across devices, so a $TMPDIR on another mount (tmpfs, a different
+// volume) would make writeFile fall back to copying. Pointing BULLETLOG_TMPDIR
+// elsewhere trades that atomicity for keeping temp files out of the log's
+// directory.
+func getTmpDir(path string) string {
+	dir, ok := lookupSetting("BULLETLOG_TMPDIR")
+	if ok {
+		return dir
+	}
+	return filepath.Dir(path)
+}
+
+const dateFormat = bulletlog.DateFormat
+
+func getDate() (time.Time, error) {
+	date, ok := os.LookupEnv("BULLETLOG_DATE")
+	if ok {
+		return time.Parse(dateFormat, date)
+	}
+	return time.Now().Truncate(24 * time.Hour), nil
+}
+
+// getWorkingDate returns the date given with --date, falling back to getDate.
+func getWorkingDate(c *cli.Context) (time.Time, error) {
+	if date := c.String("date"); date != "" {
+		return parseDate(date)
+	}
+	return getDate()
+}
+
+var relativeDatePattern = regexp.MustCompile(`^([+-]\d+)([dwmy])$`)
+
+// parseDate parses a date given on the command line. Besides YYYYMMDD and
+// YYYY-MM-DD it takes today, yesterday and tomorrow; an offset from today
+// such as -2d, +1w, +3m or -1y; and a weekday, which means the coming one
+// unless preceded by last. "next monday" and "monday" are the same day.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{dateFormat, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	today, err := getDate()
+	if err != nil {
+		return today, err
+	}
+	f := strings.Fields(strings.ToLower(s))
+	switch {
+	case len(f) == 1 && f[0] == "today":
+		return today, nil
+	case len(f) == 1 && f[0] == "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case len(f) == 1 && f[0] == "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	case len(f) == 1 && relativeDatePattern.MatchString(f[0]):
+		m := relativeDatePattern.FindStringSubmatch(f[0])
+		n, err := strconv.Atoi(m[1])
 		if err != nil {
-			break
+			return today, err
 		}
+		switch m[2] {
+		case "d":
+			return today.AddDate(0, 0, n), nil
+		case "w":
+			return today.AddDate(0, 0, 7*n), nil
+		case "m":
+			return today.AddDate(0, n, 0), nil
+		default:
+			return today.AddDate(n, 0, 0), nil
+		}
+	}
 
-		if strings.HasPrefix(line, mark) {
-			println(strings.TrimSuffix(line, "\n"))
+	direction := 1
+	if len(f) == 2 && (f[0] == "next" || f[0] == "last") {
+		if f[0] == "last" {
+			direction = -1
 		}
+		f = f[1:]
 	}
-	return nil
+	if len(f) == 1 {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := strings.ToLower(d.String())
+			if f[0] != name && f[0] != name[:3] {
+				continue
+			}
+			t := today.AddDate(0, 0, direction)
+			for t.Weekday() != d {
+				t = t.AddDate(0, 0, direction)
+			}
+			return t, nil
+		}
+	}
+	return today, fmt.Errorf("Invalid date: %q", s)
 }
 
-func listTasks(c *cli.Context) error {
-	mark := "- "
+const timeFormat = bulletlog.TimeFormat
+
+// getTimeSlot returns the time-of-day sub-section new bullets are filed under
+// with --time. Slots are hourly, so `### 14:00` collects everything added
+// between 14:00 and 14:59.
+func getTimeSlot() (string, error) {
+	slot, ok := os.LookupEnv("BULLETLOG_TIME")
+	if ok {
+		t, err := time.Parse(timeFormat, slot)
+		if err != nil {
+			return "", err
+		}
+		return t.Truncate(time.Hour).Format(timeFormat), nil
+	}
+	return fmt.Sprintf("%02d:00", time.Now().Hour()), nil
+}
+
+const (
+	backlogHeader = bulletlog.BacklogHeader
+	inboxHeader   = bulletlog.InboxHeader
+	futureHeader  = bulletlog.FutureHeader
+	recurHeader   = bulletlog.RecurringHeader
+)
 
-	path := getLogPath()
+func readLines(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, ioError(err)
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	l, err := parser.Parse(file)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	return l.Lines, nil
+}
 
-	lineNumber := 0
+func writeLines(path string, lines []string) error {
+	return writeFile(path, func(w io.Writer) error {
+		return newLog(lines).Write(w)
+	})
+}
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
-		}
-		if strings.HasPrefix(line, mark) {
-			task := strings.TrimLeft(line, mark)
-			fmt.Printf("%d: %s\n", lineNumber, strings.TrimSuffix(task, "\n"))
-			lineNumber += 1
+// writeFile replaces the file at path with what write writes, so that the
+// file is either left as it was or wholly replaced. It writes a temporary
+// file in getTmpDir, flushes it to disk, gives it the mode of the file it
+// replaces and renames it over that file. A temporary directory on another
+// filesystem cannot be renamed from, so the content is then copied over the
+// file in place instead.
+func writeFile(path string, write func(w io.Writer) error) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmpfile, err := ioutil.TempFile(getTmpDir(path), ".BULLETLOG.*")
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if err := write(tmpfile); err != nil {
+		tmpfile.Close()
+		return ioError(err)
+	}
+	for _, step := range []func() error{
+		func() error { return tmpfile.Chmod(mode) },
+		tmpfile.Sync,
+		tmpfile.Close,
+	} {
+		if err := step(); err != nil {
+			tmpfile.Close()
+			return ioError(err)
 		}
 	}
 
+	if err := os.Rename(tmpfile.Name(), path); err != nil {
+		if filepath.Dir(tmpfile.Name()) == filepath.Dir(path) {
+			return ioError(err)
+		}
+		return ioError(copyFile(tmpfile.Name(), path, mode))
+	}
+	// Make the rename itself durable. Not every system can sync a
+	// directory, which leaves the rename as durable as it gets there.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
 	return nil
 }
 
-func completeTask(c *cli.Context) error {
-	taskNumber, err := strconv.Atoi(c.Args().First())
+// copyFile overwrites the file at dst with the content of src.
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
 
-	mark := "- "
+// newLog wraps lines in a bulletlog.Log set up as configured through the
+// environment.
+func newLog(lines []string) *bulletlog.Log {
+	l := bulletlog.New(lines)
+	l.Parser = parser
+	l.Ascending = sectionsAscending()
+	l.Spacing = getEntrySpacing()
+	l.OpenMarkers = listedMarkers()
+	l.DoneMark = strings.TrimSuffix(doneMark, " ")
+	l.DayTemplate = dayTemplate
+	return l
+}
 
-	path := getLogPath()
-	file, err := os.Open(path)
+// dayTemplate returns the lines a new section for date starts with: those of
+// the file BULLETLOG_DAY_TEMPLATE names, with {{date}} replaced by the date
+// as headers write it and {{weekday}} by the name of its day of the week.
+func dayTemplate(date time.Time) ([]string, error) {
+	name := getSetting("BULLETLOG_DAY_TEMPLATE")
+	if name == "" {
+		return nil, nil
+	}
+	file, err := os.Open(name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, ioError(err)
 	}
 	defer file.Close()
-
-	reader := bufio.NewReader(file)
-
-	tmpfile, err := ioutil.TempFile("", ".BULLETLOG.*")
+	l, err := parser.Parse(file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, ioError(err)
 	}
-	defer os.Remove(tmpfile.Name())
 
-	lineNumber := 0
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
-		}
-		if strings.HasPrefix(line, mark) {
-			if taskNumber == lineNumber {
-				task := strings.TrimLeft(line, mark)
-				line = fmt.Sprintf("x %s", task)
-			}
-			lineNumber += 1
+	vars := strings.NewReplacer("{{date}}", date.Format(dateFormat), "{{weekday}}", date.Weekday().String())
+	var lines []string
+	for _, line := range l.Lines {
+		if strings.TrimSpace(line) == "" && len(lines) == 0 {
+			continue
 		}
+		lines = append(lines, vars.Replace(line))
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// sectionsAscending reports whether the log keeps its newest section at the
+// bottom, as set by BULLETLOG_SECTION_ORDER=asc. By default (desc) the
+// newest section is at the top.
+func sectionsAscending() bool {
+	return getSetting("BULLETLOG_SECTION_ORDER") == "asc"
+}
+
+// findSection returns the index of the header for date, or where one belongs
+// and false.
+func findSection(lines []string, date time.Time) (int, bool, error) {
+	i, found, err := newLog(lines).FindSection(date)
+	return i, found, parseError(err)
+}
 
-		fmt.Fprintf(tmpfile, line)
+// sectionLines returns the lines of the section for date, without its header.
+func sectionLines(lines []string, date time.Time) ([]string, error) {
+	i, found, err := findSection(lines, date)
+	if err != nil || !found {
+		return nil, err
+	}
+	j := i + 1
+	for j < len(lines) && !bulletlog.IsHeader(lines[j]) {
+		j++
 	}
-	os.Rename(tmpfile.Name(), path)
+	return lines[i+1 : j], nil
+}
 
-	return nil
+// isBullet reports whether line is an entry: a marker followed by its text.
+func isBullet(line string) bool {
+	_, ok := parser.ParseEntry(line)
+	return ok
 }
 
-func main() {
-	app := &cli.App{
-		Name:  "blt",
-		Usage: "Take a log quickly like bullets.",
-		Commands: []*cli.Command{
-			{
-				Name:    "add",
-				Aliases: []string{"a", "note"},
-				Usage:   "Add a note",
-				Action:  addNote,
+var idPattern = regexp.MustCompile(`\[id:(\d+)\]`)
+
+// getID returns the stable ID of an entry carrying an `[id:N]` token.
+func getID(line string) (int, bool) {
+	m := idPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// withID gives entry the next free ID, once the log uses IDs (see reindex).
+func withID(lines []string, entry string) string {
+	if id, used := nextID(lines); used {
+		first, rest := splitEntry(entry)
+		return fmt.Sprintf("%s [id:%d]%s", first, id, rest)
+	}
+	return entry
+}
+
+// nextID returns the ID the next entry is given, and whether any entry in
+// lines has an ID at all.
+func nextID(lines []string) (int, bool) {
+	next := 1
+	used := false
+	for _, line := range lines {
+		if id, ok := getID(line); ok && isBullet(line) {
+			used = true
+			if id >= next {
+				next = id + 1
+			}
+		}
+	}
+	return next, used
+}
+
+// findID returns the index of the entry with the given ID. A migrated task
+// keeps its ID, so the copy that was carried forward wins over the one left
+// behind.
+func findID(lines []string, id int) (int, error) {
+	found := -1
+	for i, line := range lines {
+		if n, ok := getID(line); !ok || n != id || !isBullet(line) {
+			continue
+		}
+		if found < 0 || strings.HasPrefix(lines[found], migratedMark) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return 0, fmt.Errorf("No entry with ID %d", id)
+	}
+	return found, nil
+}
+
+// findEntry resolves an entry reference given on the command line: either
+// `id:N` for the entry with a stable ID, or a task number as listTasks prints
+// it. Task numbers shift as the log changes; IDs do not.
+func findEntry(lines []string, ref string, reverse bool) (int, error) {
+	if strings.HasPrefix(ref, "id:") {
+		id, err := strconv.Atoi(strings.TrimPrefix(ref, "id:"))
+		if err != nil {
+			return 0, err
+		}
+		return findID(lines, id)
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil {
+		return 0, err
+	}
+	return findTask(lines, n, reverse)
+}
+
+// findTaskRef is findEntry for commands that only apply to open tasks.
+func findTaskRef(lines []string, ref string, reverse bool) (int, error) {
+	i, err := findEntry(lines, ref, reverse)
+	if err != nil {
+		return 0, err
+	}
+	if !isListedTask(lines[i]) {
+		return 0, fmt.Errorf("%s is not an open task", ref)
+	}
+	return i, nil
+}
+
+var tagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+
+// getTags returns the distinct `#tag` words in text, without the `#`.
+func getTags(text string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, m := range tagPattern.FindAllStringSubmatch(text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			tags = append(tags, m[1])
+		}
+	}
+	return tags
+}
+
+func hasTag(text string, tag string) bool {
+	for _, t := range getTags(text) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// removeTag strips every `#tag` word from text, where getTags finds them.
+func removeTag(text string, tag string) string {
+	pattern := regexp.MustCompile(`(^|\s)#` + regexp.QuoteMeta(tag) + `([^\p{L}\p{N}_-]|$)`)
+	for pattern.MatchString(text) {
+		text = pattern.ReplaceAllString(text, "$2")
+	}
+	return strings.TrimSpace(text)
+}
+
+// getDateRange parses a pair of date flags, such as --from and --to.
+// A missing bound leaves the range open on that side.
+func getDateRange(c *cli.Context, fromFlag string, toFlag string) (from *time.Time, to *time.Time, err error) {
+	if s := c.String(fromFlag); s != "" {
+		t, err := parseDate(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &t
+	}
+	if s := c.String(toFlag); s != "" {
+		t, err := parseDate(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		to = &t
+	}
+	return from, to, nil
+}
+
+func inRange(date time.Time, from *time.Time, to *time.Time) bool {
+	return (from == nil || !date.Before(*from)) && (to == nil || !date.After(*to))
+}
+
+// sectionDate returns the date of the section containing line i, or nil when
+// the line is not under a date section.
+func sectionDate(lines []string, i int) *time.Time {
+	for ; i >= 0; i-- {
+		if bulletlog.IsHeader(lines[i]) {
+			t, err := bulletlog.ParseHeader(lines[i])
+			if err != nil {
+				return nil
+			}
+			return t
+		}
+	}
+	return nil
+}
+
+// saveLines writes lines to the log and records the change with
+// saveChange, or with --dry-run prints a unified diff of the change instead.
+func saveLines(c *cli.Context, path string, lines []string) error {
+	s, err := newStore(c, path, c.Command.FullName())
+	if err != nil {
+		return err
+	}
+	return s.Save(lines)
+}
+
+// printChange prints the change from current to lines in the log at path as
+// a unified diff.
+func printChange(path string, current []string, lines []string) error {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        withNewlines(current),
+		B:        withNewlines(lines),
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	printDiff(diff)
+	return nil
+}
+
+func withNewlines(lines []string) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = line + "\n"
+	}
+	return result
+}
+
+// printDiff prints a unified diff, colored when stdout is a terminal.
+func printDiff(diff string) {
+	color := useColor(os.Stdout)
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case !color || line == "":
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = "\x1b[1m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+		case strings.HasPrefix(line, "+"):
+			line = "\x1b[32m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+		case strings.HasPrefix(line, "-"):
+			line = "\x1b[31m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+		case strings.HasPrefix(line, "@@"):
+			line = "\x1b[36m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+		}
+		fmt.Print(line)
+	}
+}
+
+// removeLine deletes line i, along with a blank line left doubled by it.
+func removeLine(lines []string, i int) []string {
+	lines = append(lines[:i], lines[i+1:]...)
+	if i > 0 && i < len(lines) && lines[i-1] == "" && lines[i] == "" {
+		lines = append(lines[:i], lines[i+1:]...)
+	}
+	return lines
+}
+
+// insertBullet files entry at the end of the section for date, creating the
+// section if needed.
+func insertBullet(lines []string, date time.Time, slot string, entry string) ([]string, error) {
+	l := newLog(lines)
+	err := l.Append(date, slot, entry)
+	return l.Lines, parseError(err)
+}
+
+func insertSection(lines []string, i int, date time.Time, body []string) []string {
+	l := newLog(lines)
+	l.InsertSection(i, date, body)
+	return l.Lines
+}
+
+// getEntrySpacing returns the number of blank lines written between entries,
+// 1 unless BULLETLOG_ENTRY_SPACING says otherwise. Headers are always followed
+// by one blank line, and reading never depends on the spacing.
+func getEntrySpacing() int {
+	spacing, err := strconv.Atoi(getSetting("BULLETLOG_ENTRY_SPACING"))
+	if err != nil || spacing < 0 {
+		return 1
+	}
+	return spacing
+}
+
+// appendToSection adds entry at the end of the section whose header is at i.
+func appendToSection(lines []string, i int, slot string, entry string) []string {
+	l := newLog(lines)
+	l.AppendTo(i, slot, entry)
+	return l.Lines
+}
+
+// markers maps each bullet type to the marker it is written with.
+var markers = map[string]string{
+	"note":  "*",
+	"task":  "-",
+	"event": "o",
+	"habit": "+",
+}
+
+// Task markers.
+const (
+	openMark       = "- "
+	inProgressMark = "/ "
+	migratedMark   = "> "
+	cancelledMark  = "~ "
+)
+
+// doneMark is written by complete and marks tasks as done everywhere else.
+// BULLETLOG_DONE_MARKER overrides the default "x", for those used to ✓ or X.
+var doneMark = getDoneMark()
+
+// parser reads the log. The done and open markers set in the config lead
+// bullets too.
+var parser = newParser()
+
+func newParser() bulletlog.Parser {
+	markers := bulletlog.DefaultMarkers()
+	markers[strings.TrimSuffix(doneMark, " ")] = true
+	for _, m := range openMarkers {
+		markers[m] = true
+	}
+	return bulletlog.Parser{Markers: markers}
+}
+
+func getDoneMark() string {
+	mark, ok := lookupSetting("BULLETLOG_DONE_MARKER")
+	if !ok || mark == "" {
+		mark = "x"
+	}
+	return mark + " "
+}
+
+// glyphs are how markers are rendered with --glyphs. They only affect output;
+// the log itself always uses the ASCII markers.
+var glyphs = map[string]string{
+	"*": "•",
+	"-": "○",
+	"/": "◐",
+	"x": "✓",
+	">": "›",
+	"~": "✗",
+	"o": "◦",
+	"+": "◆",
+}
+
+// getOutput returns where a command prints its results: the file named by
+// --output, truncated, or stdout.
+func getOutput(c *cli.Context) (*os.File, error) {
+	path := c.String("output")
+	if path == "" {
+		return os.Stdout, nil
+	}
+	out, err := os.Create(path)
+	return out, ioError(err)
+}
+
+func closeOutput(out *os.File) {
+	if out != os.Stdout {
+		out.Close()
+	}
+}
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor reports whether output to f is colored. BULLETLOG_COLOR can be
+// set to always or never; by default only terminals get color.
+func useColor(f *os.File) bool {
+	switch getSetting("BULLETLOG_COLOR") {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return isTerminal(f)
+}
+
+// useGlyphs reports whether markers should be rendered as glyphs. Output that
+// is piped elsewhere keeps the ASCII markers.
+func useGlyphs(c *cli.Context) bool {
+	return c.Bool("glyphs") && c.String("output") == "" && isTerminal(os.Stdout)
+}
+
+func renderMarker(mark string, useGlyphs bool) string {
+	if mark+" " == doneMark {
+		mark = "x"
+	}
+	if glyph, ok := glyphs[mark]; ok && useGlyphs {
+		return glyph
+	}
+	return mark
+}
+
+// openMarkers are the markers of outstanding tasks: those counted as open by
+// the summaries, the progress of a day and the checks for tasks left behind.
+// BULLETLOG_OPEN_MARKERS overrides them with a comma-separated list such as
+// "-,/,<"; by default only "-" is open.
+var openMarkers = getOpenMarkers()
+
+func getOpenMarkers() []string {
+	value, ok := lookupSetting("BULLETLOG_OPEN_MARKERS")
+	if !ok {
+		return []string{"-"}
+	}
+	var marks []string
+	for _, m := range strings.Split(value, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			marks = append(marks, m)
+		}
+	}
+	return marks
+}
+
+func isOpenTask(line string) bool {
+	for _, m := range openMarkers {
+		if strings.HasPrefix(line, m+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// listedMarkers are the markers of the tasks listTasks numbers and complete,
+// start and cancel address: the open ones and those in progress, which are
+// still to be done whether or not they count as open.
+func listedMarkers() []string {
+	for _, m := range openMarkers {
+		if m+" " == inProgressMark {
+			return openMarkers
+		}
+	}
+	return append(append([]string{}, openMarkers...), strings.TrimSuffix(inProgressMark, " "))
+}
+
+func isListedTask(line string) bool {
+	return newLog(nil).IsOpen(line)
+}
+
+// taskMarker returns the marker of a bullet line.
+func taskMarker(line string) string {
+	return strings.SplitN(line, " ", 2)[0]
+}
+
+// Signifiers flag an entry. They follow the marker, as in "- ! ship release",
+// so they are part of the entry's text and survive completion and migration.
+const (
+	prioritySignifier = "!"
+	questionSignifier = "?"
+)
+
+// getSignifier returns the signifier the text of an entry starts with, or "".
+func getSignifier(text string) string {
+	for _, s := range []string{prioritySignifier, questionSignifier} {
+		if strings.HasPrefix(text, s+" ") {
+			return s
+		}
+	}
+	return ""
+}
+
+// taskText strips the marker from a bullet line.
+func taskText(line string) string {
+	f := strings.SplitN(line, " ", 2)
+	if len(f) < 2 {
+		return ""
+	}
+	return f[1]
+}
+
+// continuationIndent starts the continuation lines blt writes.
+const continuationIndent = "  "
+
+// splitEntry splits an entry into its first line and the rest, its
+// continuation lines each after a newline.
+func splitEntry(entry string) (string, string) {
+	if i := strings.IndexByte(entry, '\n'); i >= 0 {
+		return entry[:i], entry[i:]
+	}
+	return entry, ""
+}
+
+// foldText makes text of several lines the text of an entry, its lines after
+// the first becoming continuation lines with their common indentation
+// replaced by continuationIndent. Blank lines are left out, since a blank line
+// ends an entry.
+func foldText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	lines = strings.Split(unfoldText(strings.Join(lines, "\n")), "\n")
+	lines[0] = strings.TrimLeft(lines[0], " \t")
+	for i := 1; i < len(lines); i++ {
+		lines[i] = continuationIndent + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// unfoldText returns the text of an entry with its continuation lines
+// unindented, as foldText takes it.
+func unfoldText(text string) string {
+	lines := strings.Split(text, "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		if n := len(line) - len(strings.TrimLeft(line, " \t")); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := range lines {
+		if i > 0 {
+			lines[i] = lines[i][indent:]
+		}
+		lines[i] = strings.TrimRight(lines[i], " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// composeEntry lets the user write the text of an entry in $EDITOR, starting
+// from text or, when text is "", from the file BULLETLOG_ENTRY_TEMPLATE names.
+// Leaving the text empty or as it started adds nothing.
+func composeEntry(text string) (string, error) {
+	path, err := getLogPath()
+	if err != nil {
+		return "", err
+	}
+	if name := getSetting("BULLETLOG_ENTRY_TEMPLATE"); text == "" && name != "" {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", ioError(err)
+		}
+		text = strings.TrimRight(string(data), "\n")
+	}
+
+	composed, err := editText(path, text)
+	if err != nil {
+		return "", err
+	}
+	if composed == "" || composed == foldText(text) {
+		return "", errors.New("Nothing written; no entry added")
+	}
+	return composed, nil
+}
+
+// readMultiline reads the text of an entry from stdin up to EOF. Its lines
+// after the first become continuation lines.
+func readMultiline() (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "Enter the text, then press Ctrl-D on a new line:")
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", ioError(err)
+	}
+	text := foldText(string(data))
+	if text == "" {
+		return "", errors.New("No text on stdin")
+	}
+	return text, nil
+}
+
+func addNote(c *cli.Context) error {
+	if c.Bool("stdin-json") {
+		return addFromJSON(c)
+	}
+	return addBullet(c, "*")
+}
+
+func addTask(c *cli.Context) error {
+	return addBullet(c, "-")
+}
+
+func addEvent(c *cli.Context) error {
+	return addBullet(c, "o")
+}
+
+func addHabit(c *cli.Context) error {
+	return addBullet(c, markers["habit"])
+}
+
+// addBullet adds an entry with marker mark whose text is the arguments joined
+// with spaces. Without arguments, or with `-`, the text is read from stdin.
+func addBullet(c *cli.Context, mark string) error {
+	// cli stops parsing flags at `-`, which leaves the flags given after it
+	// among the arguments. Words of the text that look like flags, and
+	// anything after `--`, are the text's.
+	args := c.Args().Slice()
+	if len(args) > 1 && args[0] == "-" && isFlag(c.Command.Flags, args[1]) {
+		return fmt.Errorf("%s must come before -", args[1])
+	}
+	note := strings.Join(args, " ")
+	switch {
+	case c.Bool("editor"):
+		text, err := composeEntry(note)
+		if err != nil {
+			return err
+		}
+		note = text
+	case c.Bool("multiline") || c.NArg() == 0 || note == "-":
+		text, err := readMultiline()
+		if err != nil {
+			return err
+		}
+		note = text
+	}
+	// Whatever is appended to the entry goes on its first line.
+	note, more := splitEntry(note)
+
+	switch {
+	case c.Bool("priority"):
+		note = fmt.Sprintf("%s %s", prioritySignifier, note)
+	case c.Bool("question"):
+		note = fmt.Sprintf("%s %s", questionSignifier, note)
+	}
+
+	entry := fmt.Sprintf("%s %s", mark, note)
+	if due := c.String("due"); due != "" {
+		t, err := parseDate(due)
+		if err != nil {
+			return err
+		}
+		entry = fmt.Sprintf("%s due:%s", entry, t.Format(dateFormat))
+	}
+	if file := c.String("attach"); file != "" {
+		path, err := getLogPath()
+		if err != nil {
+			return err
+		}
+		if file, err = attachmentPath(path, file); err != nil {
+			return err
+		}
+		entry = fmt.Sprintf("%s (file:%s)", entry, file)
+	}
+	every := strings.ToLower(c.String("every"))
+	if every != "" {
+		if _, err := parseEvery(every); err != nil {
+			return err
+		}
+		entry = fmt.Sprintf("%s every:%s", entry, every)
+	}
+	entry += more
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+
+	slot := ""
+	if c.Bool("time") {
+		slot, err = getTimeSlot()
+		if err != nil {
+			return err
+		}
+	}
+
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	if every != "" {
+		lines, i := findUndated(lines, recurHeader)
+		return log.Save(appendToSection(lines, i, "", withID(lines, entry)))
+	}
+	if name := c.String("to"); name != "" {
+		i, ok := findCollection(lines, name)
+		if !ok {
+			return fmt.Errorf("No such collection: %s", name)
+		}
+		return log.Save(appendToSection(lines, i, "", withID(lines, entry)))
+	}
+
+	_, found, err := findSection(lines, date)
+	if err != nil {
+		return err
+	}
+	intention := ""
+	if !found && c.String("date") == "" {
+		intention = promptIntention()
+	}
+	if intention == "" {
+		_, err := log.AppendEntry(date, slot, entry)
+		return err
+	}
+
+	// The day's intention goes first, in the same change as the entry.
+	lines, err = startDay(lines, date)
+	if err != nil {
+		return err
+	}
+	lines, err = insertBullet(lines, date, "", fmt.Sprintf("%s %s", markers["note"], intention))
+	if err != nil {
+		return err
+	}
+	lines, err = insertBullet(lines, date, slot, withID(lines, entry))
+	if err != nil {
+		return err
+	}
+	return log.Save(lines)
+}
+
+const defaultNewDayPrompt = "What is your top intention for today? "
+
+// promptIntention asks for the day's intention when a new day is started, if
+// BULLETLOG_PROMPT_ON_NEW_DAY is true and blt runs interactively. The prompt
+// text can be changed with BULLETLOG_NEW_DAY_PROMPT. It returns "" when
+// there is nothing to record.
+func promptIntention() string {
+	enabled, _ := strconv.ParseBool(getSetting("BULLETLOG_PROMPT_ON_NEW_DAY"))
+	if !enabled || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return ""
+	}
+
+	prompt, ok := lookupSetting("BULLETLOG_NEW_DAY_PROMPT")
+	if !ok {
+		prompt = defaultNewDayPrompt
+	}
+	fmt.Print(prompt)
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// jsonEntry is an entry as add --stdin-json reads it and --json prints it.
+// Only Type, Text and Date are read.
+type jsonEntry struct {
+	ID     int      `json:"id,omitempty"`
+	Number *int     `json:"number,omitempty"`
+	Line   int      `json:"line,omitempty"`
+	Type   string   `json:"type"`
+	Status string   `json:"status,omitempty"`
+	Text   string   `json:"text"`
+	Tags   []string `json:"tags,omitempty"`
+	Due    string   `json:"due,omitempty"`
+	Date   string   `json:"date"`
+
+	Collection string `json:"collection,omitempty"`
+	// Archive is the archive file holding the entry, for search --all.
+	Archive string `json:"archive,omitempty"`
+}
+
+// newJSONEntry describes the bullet line for --json. n is the line of the
+// file it starts on, as bulletlog.LineNumber counts them, or 0 when it is in
+// none; and date the date of its section.
+func newJSONEntry(line string, n int, date *time.Time) jsonEntry {
+	e := jsonEntry{
+		Line: n,
+		Type: entryType(line),
+		Text: strings.TrimSpace(unfoldText(idPattern.ReplaceAllString(taskText(line), ""))),
+		Tags: getTags(taskText(line)),
+	}
+	if id, ok := getID(line); ok {
+		e.ID = id
+	}
+	if date != nil {
+		e.Date = date.Format(dateFormat)
+	}
+	if due, ok := getDue(line); ok {
+		e.Due = due.Format(dateFormat)
+	}
+	if e.Type == "task" {
+		e.Status = taskStatus(line)
+	}
+	return e
+}
+
+// taskStatus names the state a task line is in.
+func taskStatus(line string) string {
+	switch {
+	case strings.HasPrefix(line, inProgressMark):
+		return "in-progress"
+	case isOpenTask(line):
+		return "open"
+	case strings.HasPrefix(line, doneMark):
+		return "done"
+	case strings.HasPrefix(line, migratedMark):
+		return "migrated"
+	case strings.HasPrefix(line, cancelledMark):
+		return "cancelled"
+	}
+	return taskMarker(line)
+}
+
+// wantJSON reports whether --json was given, to blt or to the command.
+func wantJSON(c *cli.Context) bool {
+	for _, ctx := range c.Lineage() {
+		if ctx.Bool("json") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON prints v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// addFromJSON adds a JSON array of entries read from stdin in a single
+// rewrite. Entries without a date go to the working date. Nothing is written
+// unless every entry is valid.
+func addFromJSON(c *cli.Context) error {
+	var entries []jsonEntry
+	if err := json.NewDecoder(os.Stdin).Decode(&entries); err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+
+	dates := make([]time.Time, len(entries))
+	for i, e := range entries {
+		if _, ok := markers[e.Type]; !ok {
+			return fmt.Errorf("Unknown entry type: %q", e.Type)
+		}
+		dates[i] = today
+		if e.Date != "" {
+			dates[i], err = time.Parse(dateFormat, e.Date)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		entry := fmt.Sprintf("%s %s", markers[e.Type], e.Text)
+		lines, err = insertBullet(lines, dates[i], "", entry)
+		if err != nil {
+			return err
+		}
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %d entries\n", len(entries))
+	return nil
+}
+
+const captureFormat = "20060102 15:04"
+
+// capture files a note in the inbox, stamped with the current time rather
+// than the working date. The inbox is created at the top of the log.
+func capture(c *cli.Context) error {
+	text := c.Args().First()
+	if text == "" {
+		return errors.New("Nothing to capture")
+	}
+	entry := fmt.Sprintf("%s [%s] %s", markers["note"], time.Now().Format(captureFormat), text)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	lines, inbox := findInbox(lines)
+	lines = appendToSection(lines, inbox, "", entry)
+
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	return nil
+}
+
+// inboxItems returns the indexes of the entries in the inbox.
+func inboxItems(lines []string) []int {
+	var items []int
+	inInbox := false
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			inInbox = line == inboxHeader
+			continue
+		}
+		if inInbox && isBullet(line) {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+func listInbox(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	for n, i := range inboxItems(lines) {
+		fmt.Fprintf(out, "%d: %s\n", n, taskText(lines[i]))
+	}
+	return nil
+}
+
+// findCollection returns the index of the header of the collection called
+// name, ignoring case.
+func findCollection(lines []string, name string) (int, bool) {
+	for i, line := range lines {
+		if bulletlog.IsCollection(line) && strings.EqualFold(bulletlog.CollectionName(line), name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// listCollections prints the collections in the log with how many entries
+// each holds.
+func listCollections(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	sections, err := log.ListSections()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, s := range sections {
+		if bulletlog.IsCollection(s.Header) {
+			fmt.Fprintf(w, "%s\t%d\n", bulletlog.CollectionName(s.Header), len(s.Entries))
+		}
+	}
+	return w.Flush()
+}
+
+// createCollection adds an empty collection at the bottom of the log.
+func createCollection(c *cli.Context) error {
+	name := strings.TrimSpace(c.Args().First())
+	if name == "" {
+		return errors.New("No collection name given")
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	if _, ok := findCollection(lines, name); ok {
+		return fmt.Errorf("Collection %s already exists", name)
+	}
+
+	if len(lines) > 0 && lines[len(lines)-1] != "" {
+		lines = append(lines, "")
+	}
+	lines = append(lines, bulletlog.CollectionPrefix+name, "")
+	return log.Save(lines)
+}
+
+// showCollection prints the entries of a collection.
+func showCollection(c *cli.Context) error {
+	name := c.Args().First()
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	start, ok := findCollection(lines, name)
+	if !ok {
+		return fmt.Errorf("No such collection: %s", name)
+	}
+
+	entries := []jsonEntry{}
+	for i := start + 1; i < len(lines) && !bulletlog.IsHeader(lines[i]); i++ {
+		if !isBullet(lines[i]) {
+			continue
+		}
+		if wantJSON(c) {
+			e := newJSONEntry(lines[i], bulletlog.LineNumber(lines, i), nil)
+			e.Collection = bulletlog.CollectionName(lines[start])
+			entries = append(entries, e)
+			continue
+		}
+		fmt.Fprintln(out, lines[i])
+	}
+	if wantJSON(c) {
+		return writeJSON(out, entries)
+	}
+	return nil
+}
+
+var capturedPattern = regexp.MustCompile(`^\[\d{8} \d{2}:\d{2}\] `)
+
+// promoteInbox moves an inbox item into the section for the working date (or
+// --date), as a note or, with --task, as a task. The capture time is dropped.
+func promoteInbox(c *cli.Context) error {
+	n, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return err
+	}
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	items := inboxItems(lines)
+	if n < 0 || n >= len(items) {
+		return fmt.Errorf("No such inbox item: %d", n)
+	}
+	i := items[n]
+
+	mark := markers["note"]
+	if c.Bool("task") {
+		mark = markers["task"]
+	}
+	entry := fmt.Sprintf("%s %s", mark, capturedPattern.ReplaceAllString(taskText(lines[i]), ""))
+	lines = removeLine(lines, i)
+
+	lines, err = insertBullet(lines, date, "", entry)
+	if err != nil {
+		return err
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	return nil
+}
+
+// editorArgs returns the arguments that make editor open path at line, for
+// the editors whose line-jump syntax is known. Other editors just get path.
+func editorArgs(editor string, path string, line int) []string {
+	if line <= 0 {
+		return []string{path}
+	}
+	switch filepath.Base(editor) {
+	case "vi", "vim", "nvim", "view", "nano", "emacs", "emacsclient", "micro", "kak", "ne", "joe":
+		return []string{fmt.Sprintf("+%d", line), path}
+	case "code", "codium":
+		return []string{"-g", fmt.Sprintf("%s:%d", path, line)}
+	case "subl":
+		return []string{fmt.Sprintf("%s:%d", path, line)}
+	}
+	return []string{path}
+}
+
+// runEditor opens path in $EDITOR (vi by default), at line when it is
+// positive and the editor supports it.
+func runEditor(path string, line int) error {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	args := append(editor[1:], editorArgs(editor[0], path, line)...)
+
+	cmd := exec.Command(editor[0], args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// editLog opens the log in $EDITOR, positioned at the section for --date if
+// given. Given an entry, it edits just that entry instead.
+func editLog(c *cli.Context) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	if c.NArg() > 0 {
+		return editEntry(c, path)
+	}
+
+	line := 0
+	if c.String("date") != "" {
+		date, err := getWorkingDate(c)
+		if err != nil {
+			return err
+		}
+		lines, err := readLog(path)
+		if err != nil {
+			return err
+		}
+		i, found, err := findSection(lines, date)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("No section for %s", date.Format(dateFormat))
+		}
+		line = bulletlog.LineNumber(lines, i)
+	}
+
+	before, err := readLog(path)
+	if err != nil {
+		return err
+	}
+	s, err := getStorage()
+	if err != nil {
+		return err
+	}
+	if _, ok := s.(textStorage); !ok {
+		return editCopy(c, path, line, before)
+	}
+	if err := autoBackup(path); err != nil {
+		return err
+	}
+	if err := runEditor(path, line); err != nil {
+		return err
+	}
+	after, err := readLog(path)
+	if err != nil {
+		return err
+	}
+	return recordChange(c, path, "edit", before, after)
+}
+
+// editCopy edits a log not kept as text through a plain-text copy of its
+// lines, saving the copy back to it.
+func editCopy(c *cli.Context, path string, line int, lines []string) error {
+	file, err := ioutil.TempFile(getTmpDir(path), ".BULLETLOG-edit-")
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.Remove(file.Name())
+
+	err = newLog(lines).Write(file)
+	file.Close()
+	if err != nil {
+		return ioError(err)
+	}
+	if err := runEditor(file.Name(), line); err != nil {
+		return err
+	}
+	edited, err := readLines(file.Name())
+	if err != nil {
+		return err
+	}
+	return saveChange(c, path, "edit", lines, edited)
+}
+
+// getShowDate returns the day show prints: the date given as its argument,
+// with --date or --yesterday, or else the working date.
+func getShowDate(c *cli.Context) (time.Time, error) {
+	if arg := c.Args().First(); arg != "" {
+		return parseDate(arg)
+	}
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return date, err
+	}
+	if c.Bool("yesterday") {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date, nil
+}
+
+// editEntry replaces the text of the entry given as the first argument,
+// keeping its marker and ID. The new text is the second argument or, without
+// one, what is left after editing the current text in $EDITOR.
+func editEntry(c *cli.Context, path string) error {
+	lines, err := readLog(path)
+	if err != nil {
+		return err
+	}
+	i, err := findEntry(lines, c.Args().Get(0), c.Bool("reverse"))
+	if err != nil {
+		return err
+	}
+
+	text := c.Args().Get(1)
+	if c.NArg() < 2 {
+		text, err = editText(path, unfoldText(taskText(lines[i])))
+		if err != nil {
+			return err
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("The new text is empty")
+	}
+
+	lines[i] = replaceText(lines[i], text)
+
+	if err := saveLines(c, path, lines); err != nil {
+		return err
+	}
+	return nil
+}
+
+// replaceText gives a bullet line new text, keeping its marker and ID.
+func replaceText(line string, text string) string {
+	if id, ok := getID(line); ok && !idPattern.MatchString(text) {
+		first, rest := splitEntry(text)
+		text = fmt.Sprintf("%s [id:%d]%s", first, id, rest)
+	}
+	return fmt.Sprintf("%s %s", taskMarker(line), text)
+}
+
+// editText lets the user edit text in $EDITOR, through a scratch file next to
+// the log, and returns the result with its lines after the first folded into
+// continuation lines.
+func editText(path string, text string) (string, error) {
+	file, err := ioutil.TempFile(getTmpDir(path), ".BULLETLOG-edit-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = fmt.Fprintln(file, text)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+	if err := runEditor(file.Name(), 1); err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return "", ioError(err)
+	}
+	return foldText(string(data)), nil
+}
+
+// showLog prints the section for the day given by getShowDate or, with --all,
+// the whole log. --hide-done and --hide-cancelled leave out completed and
+// cancelled tasks, and --hide-empty then also leaves out sections with
+// nothing left to show.
+func showLog(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	log, err := newStore(c, path, c.Command.FullName())
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	parts := []shownPart{{lines: lines, numbers: bulletlog.LineNumbers(lines)}}
+	if !c.Bool("all") {
+		date, err := getShowDate(c)
+		if err != nil {
+			return err
+		}
+		i, found, err := findSection(lines, date)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("No section for %s", date.Format(dateFormat))
+		}
+		section, _ := sectionLines(lines, date)
+		parts[0] = shownPart{lines: append([]string{lines[i]}, section...), start: i, numbers: parts[0].numbers}
+	} else {
+		// The archives hold older sections, so they go below the log, or
+		// above it when sections ascend.
+		archives, err := listArchives(path)
+		if err != nil {
+			return err
+		}
+		var archived []shownPart
+		for _, archive := range archives {
+			lines, err := readLines(archive)
+			if err != nil {
+				return err
+			}
+			archived = append(archived, shownPart{lines: lines, numbers: bulletlog.LineNumbers(lines), archive: filepath.Base(archive)})
+		}
+		if sectionsAscending() {
+			parts = append(archived, parts...)
+		} else {
+			parts = append(parts, archived...)
+		}
+	}
+	if ascending, ok, err := getOrder(c); err != nil {
+		return err
+	} else if ok {
+		// The archives are older than the log, and each holds one month.
+		if ascending != sectionsAscending() {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		for i := range parts {
+			if parts[i].lines, parts[i].starts, err = orderSections(parts[i].lines, ascending); err != nil {
+				return err
+			}
+		}
+	}
+
+	if wantJSON(c) {
+		entries := []jsonEntry{}
+		for _, p := range parts {
+			for j, line := range p.lines {
+				if !isBullet(line) || c.Bool("hide-done") && strings.HasPrefix(line, doneMark) || c.Bool("hide-cancelled") && strings.HasPrefix(line, cancelledMark) {
+					continue
+				}
+				e := newJSONEntry(line, p.number(j), sectionDate(p.lines, j))
+				e.Archive = p.archive
+				entries = append(entries, e)
+			}
+		}
+		return writeJSON(out, entries)
+	}
+
+	for _, p := range parts {
+		for _, line := range shownLines(c, p.lines) {
+			fmt.Fprintln(out, line)
+		}
+	}
+	return nil
+}
+
+// shownPart is a run of lines show prints: the log, or one of its archives.
+type shownPart struct {
+	lines []string
+	// start is the index of the first line in the file, and archive the name
+	// of the archive file, "" for the log. Once the lines are reordered,
+	// starts holds the index in the file of each of them instead.
+	start   int
+	starts  []int
+	archive string
+	// numbers are the lines of the file each line of the file starts on.
+	numbers []int
+}
+
+// number returns the line of the file the j-th line of p starts on.
+func (p shownPart) number(j int) int {
+	if p.starts != nil {
+		return p.numbers[p.starts[j]]
+	}
+	return p.numbers[p.start+j]
+}
+
+// shownLines returns lines without what --hide-done, --hide-cancelled and
+// --hide-empty leave out.
+func shownLines(c *cli.Context, lines []string) []string {
+	if c.Bool("hide-done") || c.Bool("hide-cancelled") {
+		var shown []string
+		for _, line := range lines {
+			if c.Bool("hide-done") && strings.HasPrefix(line, doneMark) {
+				continue
+			}
+			if c.Bool("hide-cancelled") && strings.HasPrefix(line, cancelledMark) {
+				continue
+			}
+			// Collapse the blank lines left around removed entries.
+			if line == "" && len(shown) > 0 && shown[len(shown)-1] == "" {
+				continue
+			}
+			shown = append(shown, line)
+		}
+		lines = shown
+	}
+
+	if c.Bool("hide-empty") {
+		var shown []string
+		for i := 0; i < len(lines); i++ {
+			if !bulletlog.IsHeader(lines[i]) {
+				shown = append(shown, lines[i])
+				continue
+			}
+			j := i + 1
+			empty := true
+			for ; j < len(lines) && !bulletlog.IsHeader(lines[j]); j++ {
+				if isBullet(lines[j]) {
+					empty = false
+				}
+			}
+			if !empty {
+				shown = append(shown, lines[i:j]...)
+			}
+			i = j - 1
+		}
+		lines = shown
+	}
+	return lines
+}
+
+const progressWidth = 10
+
+// progressBar renders done out of total as a bar of progressWidth cells,
+// green on a terminal.
+func progressBar(done int, total int, color bool) string {
+	filled := 0
+	if total > 0 {
+		filled = done * progressWidth / total
+	}
+	bar := strings.Repeat("■", filled)
+	if color {
+		bar = "\x1b[32m" + bar + "\x1b[0m"
+	}
+	return bar + strings.Repeat("□", progressWidth-filled)
+}
+
+// showToday prints the working date's section, headed by a progress bar of
+// its tasks unless --quiet is given.
+func showToday(c *cli.Context) error {
+	if !c.Bool("quiet") && !wantJSON(c) {
+		date, err := getDate()
+		if err != nil {
+			return err
+		}
+		path, err := getLogPath()
+		if err != nil {
+			return err
+		}
+		log, err := newStore(c, path, c.Command.FullName())
+		if err != nil {
+			return err
+		}
+		lines, err := log.Load()
+		if err != nil {
+			return err
+		}
+		section, err := sectionLines(lines, date)
+		if err != nil {
+			return err
+		}
+
+		done, total := 0, 0
+		for _, line := range section {
+			if strings.HasPrefix(line, doneMark) {
+				done += 1
+				total += 1
+			} else if isOpenTask(line) {
+				total += 1
+			}
+		}
+		color := c.String("output") == "" && useColor(os.Stdout)
+		fmt.Printf("%s [%s] %d/%d\n", date.Format(dateFormat), progressBar(done, total, color), done, total)
+		if goal, err := getDailyGoal(); err != nil {
+			return err
+		} else if goal > 0 {
+			progress, err := goalProgress(path, lines, date)
+			if err != nil {
+				return err
+			}
+			fmt.Println(progress)
+		}
+	}
+	return showLog(c)
+}
+
+// flush adds every line of a scratch file as a note for the working date in a
+// single rewrite, then empties the scratch file. Blank lines and `#` comments
+// are skipped.
+func flush(c *cli.Context) error {
+	scratch := c.Args().First()
+	if scratch == "" {
+		return errors.New("No scratch file given")
+	}
+	captured, err := readLines(scratch)
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	flushed := 0
+	for _, line := range captured {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines, err = insertBullet(lines, date, "", fmt.Sprintf("%s %s", markers["note"], line))
+		if err != nil {
+			return err
+		}
+		flushed += 1
+	}
+
+	if flushed > 0 {
+		if err := log.Save(lines); err != nil {
+			return err
+		}
+	}
+	if c.Bool("dry-run") {
+		fmt.Printf("Would flush %d notes\n", flushed)
+		return nil
+	}
+	if err := os.Truncate(scratch, 0); err != nil {
+		return err
+	}
+	fmt.Printf("Flushed %d notes\n", flushed)
+	return nil
+}
+
+// listOptions controls how writeBullets and writeTasks render bullets.
+type listOptions struct {
+	times   bool
+	glyphs  bool
+	age     bool
+	sortBy  string
+	reverse bool
+	summary bool
+	// status lists only the tasks with this status, when set.
+	status string
+	// cancelled also lists cancelled tasks, after the open ones.
+	cancelled bool
+	// json prints a JSON array of entries instead of lines.
+	json bool
+	// priority lists only bullets flagged with prioritySignifier.
+	priority bool
+	// tag lists only bullets with this tag, when set.
+	tag string
+	// since and until restrict listings to the date sections between them.
+	since, until *time.Time
+	// stale flags tasks older than this many days; 0 disables it.
+	stale     int
+	onlyStale bool
+	color     bool
+	today     time.Time
+}
+
+// defaultStaleDays is the threshold --only-stale uses without --stale.
+const defaultStaleDays = 7
+
+func getListOptions(c *cli.Context) (listOptions, error) {
+	opts := listOptions{
+		times:   c.Bool("times"),
+		glyphs:  useGlyphs(c),
+		age:     c.Bool("age"),
+		sortBy:  c.String("sort"),
+		reverse: c.Bool("reverse"),
+		summary: c.Bool("summary"),
+		status:  c.String("status"),
+
+		priority:  c.Bool("priority"),
+		cancelled: c.Bool("cancelled"),
+		json:      wantJSON(c),
+		tag:       strings.TrimPrefix(c.String("tag"), "#"),
+		stale:     c.Int("stale"),
+		onlyStale: c.Bool("only-stale"),
+		color:     c.String("output") == "" && useColor(os.Stdout),
+	}
+	var err error
+	opts.since, opts.until, err = getDateRange(c, "since", "until")
+	if err != nil {
+		return opts, err
+	}
+	if days := c.Int("last"); days > 0 {
+		today, err := getDate()
+		if err != nil {
+			return opts, err
+		}
+		since := today.AddDate(0, 0, -(days - 1))
+		opts.since = &since
+	}
+	if opts.onlyStale && opts.stale <= 0 {
+		opts.stale = defaultStaleDays
+	}
+	if opts.sortBy != "" && opts.sortBy != "age" {
+		return opts, fmt.Errorf("Unknown sort order: %s", opts.sortBy)
+	}
+	if opts.status != "" && opts.status != "open" && opts.status != "in-progress" {
+		return opts, fmt.Errorf("Unknown task status: %s", opts.status)
+	}
+
+	if opts.age || opts.stale > 0 {
+		today, err := getDate()
+		if err != nil {
+			return opts, err
+		}
+		opts.today = today
+	}
+	return opts, nil
+}
+
+// inRange reports whether bullets in the section for date are listed. With
+// --since, --until or --last, bullets outside any date section are not.
+func (o listOptions) inRange(date *time.Time) bool {
+	if o.since == nil && o.until == nil {
+		return true
+	}
+	return date != nil && inRange(*date, o.since, o.until)
+}
+
+func listNotes(c *cli.Context) error {
+	return listBullets(c, markers["note"])
+}
+
+func listEvents(c *cli.Context) error {
+	return listBullets(c, markers["event"])
+}
+
+// listBullets lists every bullet with the given marker.
+func listBullets(c *cli.Context, mark string) error {
+	opts, err := getListOptions(c)
+	if err != nil {
+		return err
+	}
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	log, err := openLog(path)
+	if err != nil {
+		return err
+	}
+	return writeBullets(out, log, mark, opts)
+}
+
+// writeBullets writes the bullets with marker mark in the log read from r to
+// w.
+func writeBullets(w io.Writer, r io.Reader, mark string, opts listOptions) error {
+	mark += " "
+
+	scanner := parser.NewScanner(r)
+
+	slot := ""
+	var date *time.Time
+	entries := []jsonEntry{}
+
+	for scanner.Scan() {
+		n, line, kind := scanner.Line().Number, scanner.Line().Text, scanner.Line().Kind
+
+		switch kind {
+		case bulletlog.HeaderLine:
+			slot = ""
+			date, _ = bulletlog.ParseHeader(line)
+		case bulletlog.SubHeaderLine:
+			slot, _ = bulletlog.ParseSubHeader(line)
+		}
+
+		if kind == bulletlog.EntryLine && strings.HasPrefix(line, mark) && opts.inRange(date) {
+			priority := getSignifier(taskText(line)) == prioritySignifier
+			if opts.priority && !priority || opts.tag != "" && !hasTag(taskText(line), opts.tag) {
+				continue
+			}
+			if opts.json {
+				entries = append(entries, newJSONEntry(line, n, date))
+				continue
+			}
+			line = fmt.Sprintf("%s %s", renderMarker(taskMarker(line), opts.glyphs), taskText(line))
+			if opts.times && slot != "" {
+				line = fmt.Sprintf("%s %s", slot, line)
+			}
+			if priority && opts.color {
+				line = "\x1b[1m" + line + "\x1b[0m"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ioError(err)
+	}
+	if opts.json {
+		return writeJSON(w, entries)
+	}
+	return nil
+}
+
+type openTask struct {
+	number   int
+	text     string
+	date     *time.Time
+	priority bool
+	tags     []string
+	// entry describes the task for --json.
+	entry jsonEntry
+}
+
+func (t openTask) hasTag(tag string) bool {
+	for _, s := range t.tags {
+		if s == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func listTasks(c *cli.Context) error {
+	opts, err := getListOptions(c)
+	if err != nil {
+		return err
+	}
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	log, err := openLog(path)
+	if err != nil {
+		return err
+	}
+	return writeTasks(out, log, opts)
+}
+
+// writeTasks writes the open tasks in the log read from r to w, numbered as
+// complete and the other task commands expect.
+func writeTasks(w io.Writer, r io.Reader, opts listOptions) error {
+	scanner := parser.NewScanner(r)
+
+	lineNumber := 0
+	slot := ""
+	deferred := false
+	var date *time.Time
+	var tasks []openTask
+	var done, cancelled, migrated int
+	var dropped []jsonEntry
+
+	for scanner.Scan() {
+		n, line, kind := scanner.Line().Number, scanner.Line().Text, scanner.Line().Kind
+
+		switch kind {
+		case bulletlog.HeaderLine:
+			deferred = bulletlog.IsDeferred(line)
+			slot = ""
+			date, _ = bulletlog.ParseHeader(line)
+		case bulletlog.SubHeaderLine:
+			slot, _ = bulletlog.ParseSubHeader(line)
+		}
+		if kind != bulletlog.EntryLine {
+			continue
+		}
+
+		if isListedTask(line) && !deferred {
+			task := taskText(line)
+			if mark := taskMarker(line); mark+" " != openMark || opts.glyphs {
+				task = fmt.Sprintf("%s %s", renderMarker(mark, opts.glyphs), task)
+			}
+			if opts.times && slot != "" {
+				task = fmt.Sprintf("%s %s", slot, task)
+			}
+			priority := getSignifier(taskText(line)) == prioritySignifier
+			tags := getTags(taskText(line))
+			entry := newJSONEntry(line, n, date)
+			tasks = append(tasks, openTask{lineNumber, task, date, priority, tags, entry})
+			lineNumber += 1
+		} else if !deferred && opts.inRange(date) {
+			switch {
+			case strings.HasPrefix(line, doneMark):
+				done += 1
+			case strings.HasPrefix(line, cancelledMark):
+				cancelled += 1
+				if opts.cancelled && (opts.tag == "" || hasTag(taskText(line), opts.tag)) {
+					dropped = append(dropped, newJSONEntry(line, n, date))
+				}
+			case strings.HasPrefix(line, migratedMark):
+				migrated += 1
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ioError(err)
+	}
+
+	open := 0
+	for _, t := range tasks {
+		if opts.inRange(t.date) {
+			open += 1
+		}
+	}
+
+	if opts.reverse {
+		for i, j := 0, len(tasks)-1; i < j; i, j = i+1, j-1 {
+			tasks[i], tasks[j] = tasks[j], tasks[i]
+		}
+		for i := range tasks {
+			tasks[i].number = i
+		}
+	}
+
+	if opts.sortBy == "age" {
+		// Oldest first; tasks outside any date section go last.
+		sort.SliceStable(tasks, func(i, j int) bool {
+			if tasks[i].date == nil || tasks[j].date == nil {
+				return tasks[j].date == nil && tasks[i].date != nil
+			}
+			return tasks[i].date.Before(*tasks[j].date)
+		})
+	}
+
+	entries := []jsonEntry{}
+	for _, t := range tasks {
+		age := -1
+		if t.date != nil {
+			age = int(opts.today.Sub(*t.date).Hours() / 24)
+		}
+		stale := opts.stale > 0 && age > opts.stale
+		if !opts.inRange(t.date) || opts.onlyStale && !stale || opts.priority && !t.priority || opts.tag != "" && !t.hasTag(opts.tag) || opts.status != "" && t.entry.Status != opts.status {
+			continue
+		}
+		if opts.json {
+			number := t.number
+			t.entry.Number = &number
+			entries = append(entries, t.entry)
+			continue
+		}
+
+		line := fmt.Sprintf("%d: %s", t.number, t.text)
+		if opts.age && age >= 0 {
+			line = fmt.Sprintf("%s (%dd)", line, age)
+		}
+		if t.priority && opts.color {
+			line = "\x1b[1m" + line + "\x1b[0m"
+		}
+		if stale {
+			line += " (stale)"
+			if opts.color {
+				line = "\x1b[31m" + line + "\x1b[0m"
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	if opts.json {
+		return writeJSON(w, append(entries, dropped...))
+	}
+
+	// Cancelled tasks are not numbered: no task command applies to them.
+	for _, e := range dropped {
+		fmt.Fprintf(w, "%s %s\n", renderMarker(strings.TrimSpace(cancelledMark), opts.glyphs), e.Text)
+	}
+
+	if opts.summary {
+		fmt.Fprintf(w, "(%d open, %d done, %d cancelled, %d migrated)\n", open, done, cancelled, migrated)
+	}
+
+	return nil
+}
+
+func completeTask(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	var indexes []int
+	var completed []string
+	switch {
+	case c.IsSet("line"):
+		// Editor integrations address tasks by the line of the file they
+		// start on, counting from 1.
+		i, start := bulletlog.LineIndex(lines, c.Int("line"))
+		if !start || !isListedTask(lines[i]) {
+			return fmt.Errorf("Line %d is not an open task", c.Int("line"))
+		}
+		indexes = append(indexes, i)
+	case c.Bool("stdin"):
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return ioError(err)
+		}
+		numbers := strings.Fields(string(input))
+		if len(numbers) == 0 {
+			return errors.New("No task numbers on stdin")
+		}
+		// Resolve every number before touching the log, so a bad one
+		// completes nothing.
+		for _, s := range numbers {
+			i, err := findTaskRef(lines, s, c.Bool("reverse"))
+			if err != nil {
+				return err
+			}
+			indexes = append(indexes, i)
+		}
+		completed = numbers
+	case c.NArg() == 0 && isTerminal(os.Stdin) && isTerminal(os.Stdout):
+		indexes, err = pickTasks(lines)
+		if err != nil {
+			return err
+		}
+		if len(indexes) == 0 {
+			return nil
+		}
+	default:
+		i, err := findTaskRef(lines, c.Args().First(), c.Bool("reverse"))
+		if err != nil {
+			return err
+		}
+		indexes = append(indexes, i)
+	}
+
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	l := newLog(lines)
+	for _, i := range indexes {
+		// The same task may be given twice on stdin.
+		if !l.IsOpen(lines[i]) {
+			continue
+		}
+		if err := l.Complete(i); err != nil {
+			return err
+		}
+		if t := sectionDate(lines, i); t != nil && t.After(today) {
+			fmt.Fprintf(os.Stderr, "Warning: completing a task under future section %s\n", t.Format(dateFormat))
+		}
+	}
+
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	if len(completed) > 0 {
+		fmt.Printf("Completed %s\n", strings.Join(completed, ", "))
+	}
+	return nil
+}
+
+// reopenTask turns a completed task back into an open one, in place.
+func reopenTask(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+
+	ref := c.Args().First()
+	return log.UpdateEntry(entryLocator(ref, false), func(line string) (string, error) {
+		if !strings.HasPrefix(line, doneMark) {
+			return "", fmt.Errorf("%s is not a completed task", ref)
+		}
+		return openMark + taskText(line), nil
+	})
+}
+
+// findTask returns the index of the n-th open task outside the backlog, in the
+// same order listTasks numbers them. With reverse, tasks are numbered from the
+// bottom of the file up, as `tasks --reverse` does.
+func findTask(lines []string, n int, reverse bool) (int, error) {
+	return newLog(lines).FindTask(n, reverse)
+}
+
+func startTask(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	return log.UpdateEntry(taskLocator(c.Args().First(), c.Bool("reverse")), func(line string) (string, error) {
+		return inProgressMark + taskText(line), nil
+	})
+}
+
+// cancelTask marks an open task as cancelled. With --all-open it cancels every
+// open task in the section for the working date instead, leaving notes and
+// other bullets alone.
+func cancelTask(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	if !c.Bool("all-open") {
+		return log.UpdateEntry(taskLocator(c.Args().First(), c.Bool("reverse")), func(line string) (string, error) {
+			return cancelledMark + taskText(line), nil
+		})
+	}
+
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+	i, found, err := findSection(lines, date)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("No section for %s", date.Format(dateFormat))
+	}
+
+	count := 0
+	for j := i + 1; j < len(lines) && !bulletlog.IsHeader(lines[j]); j++ {
+		if isListedTask(lines[j]) {
+			lines[j] = cancelledMark + taskText(lines[j])
+			count++
+		}
+	}
+
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	fmt.Printf("Cancelled %d tasks in %s\n", count, date.Format(dateFormat))
+	return nil
+}
+
+// deleteEntry removes an entry from the log altogether. Use cancel to keep a
+// record of a task that no longer matters.
+func deleteEntry(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	i, err := findEntry(lines, c.Args().First(), c.Bool("reverse"))
+	if err != nil {
+		return err
+	}
+	lines = removeLine(lines, i)
+
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrateTask carries an open task forward to today: it is marked migrated in
+// its original section and re-added under today's header. With --all, every
+// open task from previous days is migrated.
+func migrateTask(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	var indexes []int
+	if c.Bool("all") {
+		indexes = staleTasks(lines, today)
+	} else {
+		i, err := findTaskRef(lines, c.Args().First(), c.Bool("reverse"))
+		if err != nil {
+			return err
+		}
+		if t := sectionDate(lines, i); t != nil && t.Equal(today) {
+			return fmt.Errorf("%s is already in today's section", c.Args().First())
+		}
+		indexes = append(indexes, i)
+	}
+
+	lines, err = migrateLines(lines, indexes, today)
+	if err != nil {
+		return err
+	}
+
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	if c.Bool("all") {
+		fmt.Printf("Migrated %d tasks\n", len(indexes))
+	}
+	return nil
+}
+
+// staleTasks returns the indexes of the open tasks from days before today,
+// and of the future log's tasks whose month has come.
+func staleTasks(lines []string, today time.Time) []int {
+	var indexes []int
+	for i, line := range lines {
+		if !isOpenTask(line) {
+			continue
+		}
+		if t := sectionDate(lines, i); t != nil && t.Before(today) {
+			indexes = append(indexes, i)
+		}
+	}
+	return append(indexes, arrivedFuture(lines, today)...)
+}
+
+// startDay is called before the section for date is created. When
+// BULLETLOG_ROLLOVER is true and date is today, it migrates the tasks
+// staleTasks returns into the new section.
+func startDay(lines []string, date time.Time) ([]string, error) {
+	enabled, _ := strconv.ParseBool(getSetting("BULLETLOG_ROLLOVER"))
+	if !enabled {
+		return lines, nil
+	}
+	today, err := getDate()
+	if err != nil || !date.Equal(today) {
+		return lines, err
+	}
+	return migrateLines(lines, staleTasks(lines, today), today)
+}
+
+// migrateLines marks the tasks at indexes migrated and re-adds them to the
+// section for today, with the marker they had, so that a task in progress
+// stays so. Tasks from the future log lose their month there.
+func migrateLines(lines []string, indexes []int, today time.Time) ([]string, error) {
+	var tasks []string
+	for _, i := range indexes {
+		mark, task := taskMarker(lines[i]), taskText(lines[i])
+		lines[i] = migratedMark + task
+		tasks = append(tasks, fmt.Sprintf("%s %s", mark, withoutMonth(task)))
+	}
+	for _, task := range tasks {
+		var err error
+		lines, err = insertBullet(lines, today, "", task)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// findBacklogTask returns the index of the n-th task in the backlog. The
+// backlog is numbered independently of the daily tasks.
+func findBacklogTask(lines []string, n int) (int, error) {
+	number := 0
+	inBacklog := false
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			inBacklog = line == backlogHeader
+		}
+		if isOpenTask(line) && inBacklog {
+			if number == n {
+				return i, nil
+			}
+			number += 1
+		}
+	}
+	return 0, fmt.Errorf("No such backlog task: %d", n)
+}
+
+func listBacklog(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	number := 0
+	inBacklog := false
+	for _, line := range lines {
+		if bulletlog.IsHeader(line) {
+			inBacklog = line == backlogHeader
+		}
+		if isOpenTask(line) && inBacklog {
+			fmt.Fprintf(out, "%d: %s\n", number, taskText(line))
+			number += 1
+		}
+	}
+	return nil
+}
+
+func pushBacklog(c *cli.Context) error {
+	taskNumber, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	i, err := findTask(lines, taskNumber, c.Bool("reverse"))
+	if err != nil {
+		return err
+	}
+	task := taskText(lines[i])
+	lines[i] = migratedMark + task
+
+	lines, backlog := findUndated(lines, backlogHeader)
+	lines = appendToSection(lines, backlog, "", openMark+task)
+
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	return nil
+}
+
+func pullBacklog(c *cli.Context) error {
+	backlogNumber, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	date, err := getDate()
+	if err != nil {
+		return err
+	}
+
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	i, err := findBacklogTask(lines, backlogNumber)
+	if err != nil {
+		return err
+	}
+	task := openMark + taskText(lines[i])
+	lines = removeLine(lines, i)
+
+	lines, err = insertBullet(lines, date, "", task)
+	if err != nil {
+		return err
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ensureToday(c *cli.Context) error {
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+	dateStr := date.Format(dateFormat)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	i, found, err := findSection(lines, date)
+	if err != nil {
+		return err
+	}
+	if found {
+		fmt.Printf("Section %s already exists\n", dateStr)
+		return nil
+	}
+
+	lines, err = startDay(lines, date)
+	if err != nil {
+		return err
+	}
+	if i, found, err = findSection(lines, date); err != nil {
+		return err
+	}
+	if !found {
+		body, err := dayTemplate(date)
+		if err != nil {
+			return err
+		}
+		if len(body) > 0 {
+			body = append(body, "")
+		}
+		lines = insertSection(lines, i, date, body)
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	if !c.Bool("dry-run") {
+		fmt.Printf("Created section %s\n", dateStr)
+	}
+	return nil
+}
+
+var attachmentPattern = regexp.MustCompile(`\(file:([^)]+)\)`)
+
+// entryType returns the type of a bullet line, as named in markers. Every
+// marker other than a note's or an event's is some state of a task.
+func entryType(line string) string {
+	switch taskMarker(line) {
+	case markers["note"]:
+		return "note"
+	case markers["event"]:
+		return "event"
+	case markers["habit"]:
+		return "habit"
+	}
+	return "task"
+}
+
+// search prints the entries matching a query with their section and line
+// number. Like grep, it fails when nothing matches, so it can be used in
+// shell conditionals.
+func search(c *cli.Context) error {
+	query := c.Args().First()
+	if query == "" {
+		return errors.New("No query given")
+	}
+	if !c.Bool("regex") {
+		query = regexp.QuoteMeta(query)
+	}
+	if c.Bool("ignore-case") {
+		query = "(?i)" + query
+	}
+	pattern, err := regexp.Compile(query)
+	if err != nil {
+		return err
+	}
+
+	typ := c.String("type")
+	if _, ok := markers[typ]; typ != "" && !ok {
+		return fmt.Errorf("Unknown entry type: %q", typ)
+	}
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	var terms []string
+	if !c.Bool("regex") {
+		terms = append(terms, c.Args().First())
+	}
+	match := func(line string) bool {
+		return (typ == "" || entryType(line) == typ) && pattern.MatchString(taskText(line))
+	}
+	found, err := log.Search(terms, match)
+	if err != nil {
+		return err
+	}
+	// With --all, the archives are searched after the log, and what is found
+	// in them is led by the archive's name.
+	files := make([]string, len(found))
+	if c.Bool("all") {
+		path, err := getLogPath()
+		if err != nil {
+			return err
+		}
+		archives, err := listArchives(path)
+		if err != nil {
+			return err
+		}
+		for _, archive := range archives {
+			lines, err := readLines(archive)
+			if err != nil {
+				return err
+			}
+			for _, f := range searchLines(lines, match) {
+				found = append(found, f)
+				files = append(files, filepath.Base(archive))
+			}
+		}
+	}
+
+	color := c.String("output") == "" && useColor(os.Stdout)
+	entries := []jsonEntry{}
+	for n, f := range found {
+		if c.Bool("quiet") {
+			break
+		}
+		section, collection := strings.TrimPrefix(f.Header, "## "), ""
+		if bulletlog.IsCollection(f.Header) {
+			collection = bulletlog.CollectionName(f.Header)
+			section = collection
+		}
+		if wantJSON(c) {
+			e := newJSONEntry(f.Line, f.Number, f.Date)
+			e.Collection = collection
+			e.Archive = files[n]
+			entries = append(entries, e)
+			continue
+		}
+		text := taskText(f.Line)
+		if color {
+			text = pattern.ReplaceAllStringFunc(text, func(m string) string {
+				return "\x1b[1;31m" + m + "\x1b[0m"
+			})
+		}
+		if files[n] != "" {
+			section = files[n] + ":" + section
+		}
+		fmt.Fprintf(out, "%s:%d: %s %s\n", section, f.Number, taskMarker(f.Line), text)
+	}
+
+	if wantJSON(c) && !c.Bool("quiet") {
+		if err := writeJSON(out, entries); err != nil {
+			return err
+		}
+	}
+	if len(found) == 0 {
+		return cli.Exit("", exitNoMatch)
+	}
+	return nil
+}
+
+// getAttachment returns the file referenced by a `(file:PATH)` token.
+func getAttachment(line string) (string, bool) {
+	m := attachmentPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// attachmentPath returns file, given relative to the current directory, as
+// openAttachment resolves it: relative to the directory of the log at path
+// when it is under it, and absolute otherwise.
+func attachmentPath(path string, file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", ioError(err)
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return "", ioError(err)
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel, nil
+	}
+	return abs, nil
+}
+
+// openAttachment opens the file attached to the n-th note that has one, or
+// lists those notes when no number is given. Relative paths are resolved
+// against the log's directory.
+func openAttachment(c *cli.Context) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	lines, err := readLog(path)
+	if err != nil {
+		return err
+	}
+
+	var notes []string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "* ") {
+			continue
+		}
+		if _, ok := getAttachment(line); ok {
+			notes = append(notes, line)
+		}
+	}
+
+	if c.NArg() == 0 {
+		for i, note := range notes {
+			fmt.Printf("%d: %s\n", i, taskText(note))
+		}
+		return nil
+	}
+
+	n, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(notes) {
+		return fmt.Errorf("No such attachment: %d", n)
+	}
+
+	file, _ := getAttachment(notes[n])
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(path), file)
+	}
+	if _, err := os.Stat(file); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", file)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", file)
+	default:
+		cmd = exec.Command("xdg-open", file)
+	}
+	return cmd.Start()
+}
+
+// moveSection relocates a single date section to its chronological position,
+// leaving the order of every other section as it is.
+func moveSection(c *cli.Context) error {
+	date, err := parseDate(c.Args().First())
+	if err != nil {
+		return err
+	}
+	dateStr := date.Format(dateFormat)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	i := -1
+	for k, line := range lines {
+		if t, err := bulletlog.ParseHeader(line); err == nil && t.Equal(date) {
+			i = k
+			break
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("No such section: %s", dateStr)
+	}
+	j := i + 1
+	for j < len(lines) && !bulletlog.IsHeader(lines[j]) {
+		j++
+	}
+
+	section := append([]string{}, lines[i:j]...)
+	if section[len(section)-1] != "" {
+		section = append(section, "")
+	}
+	rest := append(append([]string{}, lines[:i]...), lines[j:]...)
+
+	k, _, err := findSection(rest, date)
+	if err != nil {
+		return err
+	}
+	if k == i {
+		fmt.Printf("Section %s is already in place\n", dateStr)
+		return nil
+	}
+	if k > 0 && rest[k-1] != "" {
+		section = append([]string{""}, section...)
+	}
+
+	lines = append(append(append([]string{}, rest[:k]...), section...), rest[k:]...)
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	fmt.Printf("Moved section %s\n", dateStr)
+	return nil
+}
+
+// lint reports suspicious content in the log. Findings are warnings only.
+func lint(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	var date *time.Time
+	numbers := bulletlog.LineNumbers(lines)
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			date = sectionDate(lines, i)
+			continue
+		}
+		if strings.HasPrefix(line, doneMark) && date != nil && date.After(today) {
+			fmt.Fprintf(out, "%d: completed task under future section %s: %s\n", numbers[i], date.Format(dateFormat), taskText(line))
+		}
+	}
+	return nil
+}
+
+func addTag(c *cli.Context) error {
+	return retag(c, func(text string, tag string) string {
+		if hasTag(text, tag) {
+			return text
+		}
+		return fmt.Sprintf("%s #%s", text, tag)
+	})
+}
+
+func deleteTag(c *cli.Context) error {
+	return retag(c, removeTag)
+}
+
+// retag rewrites the text of every entry in the --from/--to range with fn and
+// reports how many entries changed.
+func retag(c *cli.Context, fn func(text string, tag string) string) error {
+	// `tag add work --from X` gives the flags after the tag.
+	args, err := parseTrailingFlags(c)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("Too many arguments: %s", strings.Join(args[1:], " "))
+	}
+	tag := ""
+	if len(args) == 1 {
+		tag = strings.TrimPrefix(args[0], "#")
+	}
+	if tag == "" {
+		return errors.New("No tag given")
+	}
+	from, to, err := getDateRange(c, "from", "to")
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	modified := 0
+	var date *time.Time
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			date = sectionDate(lines, i)
+			continue
+		}
+		if date == nil || !inRange(*date, from, to) || !isBullet(line) {
+			continue
+		}
+		text := fn(taskText(line), tag)
+		if text != taskText(line) {
+			lines[i] = fmt.Sprintf("%s %s", taskMarker(line), text)
+			modified += 1
+		}
+	}
+
+	if modified > 0 {
+		if err := log.Save(lines); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Modified %d entries\n", modified)
+	return nil
+}
+
+// reindex gives every entry without an `[id:N]` token the next free ID,
+// counting up from the highest existing one, and rebuilds the search index
+// when there is one.
+func reindex(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	next, _ := nextID(lines)
+
+	added := 0
+	for i, line := range lines {
+		if !isBullet(line) {
+			continue
+		}
+		if _, ok := getID(line); ok {
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s [id:%d]", line, next)
+		next += 1
+		added += 1
+	}
+
+	if added > 0 {
+		if err := log.Save(lines); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Added %d IDs\n", added)
+
+	if !searchIndexEnabled() || c.Bool("dry-run") {
+		return nil
+	}
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	idx := buildIndex(lines)
+	if err := writeIndex(path, idx); err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d entries\n", len(idx.Entries))
+	return nil
+}
+
+var duePattern = regexp.MustCompile(`(?:^|\s)due:(\d{8})(?:\s|$)`)
+
+// getDue returns the date of the `due:YYYYMMDD` token in text, if any.
+func getDue(text string) (time.Time, bool) {
+	m := duePattern.FindStringSubmatch(text)
+	if m == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(dateFormat, m[1])
+	return t, err == nil
+}
+
+type dueTask struct {
+	number int
+	text   string
+	due    time.Time
+}
+
+// dueTasks returns the open tasks due within [from, to], soonest first. A nil
+// from leaves the range open.
+func dueTasks(lines []string, from *time.Time, to time.Time) []dueTask {
+	var tasks []dueTask
+	for n, i := range newLog(lines).OpenTasks() {
+		text := taskText(lines[i])
+		due, ok := getDue(text)
+		if !ok || !inRange(due, from, &to) {
+			continue
+		}
+		tasks = append(tasks, dueTask{n, text, due})
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].due.Before(tasks[j].due)
+	})
+	return tasks
+}
+
+func writeDueTasks(c *cli.Context, tasks []dueTask) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	for _, t := range tasks {
+		fmt.Fprintf(out, "%s %d: %s\n", t.due.Format(dateFormat), t.number, t.text)
+	}
+	return nil
+}
+
+// showAgenda lists the open tasks due from today to the end of the week.
+func showAgenda(c *cli.Context) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	return writeDueTasks(c, dueTasks(lines, &today, weekStart(today).AddDate(0, 0, 6)))
+}
+
+// showOverdue lists the open tasks that were due before today.
+func showOverdue(c *cli.Context) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	return writeDueTasks(c, dueTasks(lines, nil, today.AddDate(0, 0, -1)))
+}
+
+// weekStart returns the Monday of the week containing t.
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset)
+}
+
+const monthFormat = "200601"
+
+var monthPattern = regexp.MustCompile(`(?:^|\s)month:(\d{6})(?:\s|$)`)
+
+// getMonth returns the month of the `month:YYYYMM` token in text, if any.
+func getMonth(text string) (time.Time, bool) {
+	m := monthPattern.FindStringSubmatch(text)
+	if m == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(monthFormat, m[1])
+	return t, err == nil
+}
+
+// withoutMonth strips the `month:YYYYMM` token from text.
+func withoutMonth(text string) string {
+	return strings.TrimSpace(monthPattern.ReplaceAllString(text, " "))
+}
+
+// parseMonth parses a month given as YYYYMM, YYYY-MM or a month name such as
+// oct or october. A name means the next such month, counting the current one.
+func parseMonth(s string, today time.Time) (time.Time, error) {
+	for _, layout := range []string{monthFormat, "2006-01"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	name := strings.ToLower(s)
+	for m := time.January; m <= time.December; m++ {
+		full := strings.ToLower(m.String())
+		if name != full && name != full[:3] {
+			continue
+		}
+		year := today.Year()
+		if m < today.Month() {
+			year++
+		}
+		return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return today, fmt.Errorf("Invalid month: %q", s)
+}
+
+// findUndated returns the index of header, adding the undated section at the
+// bottom of the log when there is none yet.
+func findUndated(lines []string, header string) ([]string, int) {
+	for i, line := range lines {
+		if line == header {
+			return lines, i
+		}
+	}
+	if len(lines) > 0 && lines[len(lines)-1] != "" {
+		lines = append(lines, "")
+	}
+	return append(lines, header, ""), len(lines)
+}
+
+// findInbox returns the index of the inbox header, adding the inbox at the
+// top of the log when there is none yet.
+func findInbox(lines []string) ([]string, int) {
+	for i, line := range lines {
+		if line == inboxHeader {
+			return lines, i
+		}
+	}
+	i := 0
+	for i < len(lines) && bulletlog.IsPreamble(lines[i]) {
+		i++
+	}
+	header := []string{inboxHeader, ""}
+	inbox := i
+	if i > 0 && lines[i-1] != "" {
+		header = append([]string{""}, header...)
+		inbox++
+	}
+	return append(lines[:i:i], append(header, lines[i:]...)...), inbox
+}
+
+// futureItems returns the indexes of the open tasks in the future log.
+func futureItems(lines []string) []int {
+	var items []int
+	inFuture := false
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			inFuture = line == futureHeader
+			continue
+		}
+		if inFuture && isOpenTask(line) {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// future adds a task for a later month to the future log, given as
+// "MONTH: text", or lists the future log without an argument.
+func future(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return listFuture(c)
+	}
+
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	f := strings.SplitN(c.Args().First(), ":", 2)
+	if len(f) != 2 || strings.TrimSpace(f[1]) == "" {
+		return errors.New("Give the task as MONTH: text, such as \"oct: renew passport\"")
+	}
+	month, err := parseMonth(strings.TrimSpace(f[0]), today)
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	entry := fmt.Sprintf("%s%s month:%s", openMark, strings.TrimSpace(f[1]), month.Format(monthFormat))
+	entry = withID(lines, entry)
+	lines, i := findUndated(lines, futureHeader)
+	lines = appendToSection(lines, i, "", entry)
+
+	return log.Save(lines)
+}
+
+// listFuture prints the open tasks in the future log under their months,
+// earliest month first.
+func listFuture(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	items := futureItems(lines)
+	sort.SliceStable(items, func(i, j int) bool {
+		a, _ := getMonth(lines[items[i]])
+		b, _ := getMonth(lines[items[j]])
+		return a.Before(b)
+	})
+	heading := ""
+	for _, i := range items {
+		month, ok := getMonth(lines[i])
+		h := "Someday"
+		if ok {
+			h = month.Format("January 2006")
+		}
+		if h != heading {
+			if heading != "" {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintln(out, h)
+			heading = h
+		}
+		fmt.Fprintf(out, "  %s\n", withoutMonth(taskText(lines[i])))
+	}
+	return nil
+}
+
+// arrivedFuture returns the indexes of the future log's tasks whose month has
+// come by today.
+func arrivedFuture(lines []string, today time.Time) []int {
+	var arrived []int
+	for _, i := range futureItems(lines) {
+		if month, ok := getMonth(lines[i]); ok && !month.After(today) {
+			arrived = append(arrived, i)
+		}
+	}
+	return arrived
+}
+
+var everyPattern = regexp.MustCompile(`(?:^|\s)every:(\S+)(?:\s|$)`)
+
+// parseEvery parses the rule of a recurring task: day, weekday for Monday to
+// Friday, weekdays such as mon,thu, or a day of the month from 1 to 31. A day
+// past the end of a month falls on its last day. It returns whether a date is
+// due under the rule.
+func parseEvery(rule string) (func(t time.Time) bool, error) {
+	switch rule {
+	case "day":
+		return func(time.Time) bool { return true }, nil
+	case "weekday":
+		return func(t time.Time) bool {
+			return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+		}, nil
+	}
+
+	if n, err := strconv.Atoi(rule); err == nil {
+		if n < 1 || n > 31 {
+			return nil, fmt.Errorf("Invalid day of the month: %d", n)
+		}
+		return func(t time.Time) bool {
+			last := t.AddDate(0, 1, -t.Day()).Day()
+			return t.Day() == n || t.Day() == last && n > last
+		}, nil
+	}
+
+	days := map[time.Weekday]bool{}
+	for _, name := range strings.Split(rule, ",") {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			full := strings.ToLower(d.String())
+			if name == full || name == full[:3] {
+				days[d] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Invalid recurrence: %q", rule)
+		}
+	}
+	return func(t time.Time) bool { return days[t.Weekday()] }, nil
+}
+
+// recurringRules returns the indexes of the open tasks in the RECURRING
+// section. Cancelling a rule there stops it.
+func recurringRules(lines []string) []int {
+	var rules []int
+	inRecurring := false
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			inRecurring = line == recurHeader
+			continue
+		}
+		if inRecurring && isOpenTask(line) && everyPattern.MatchString(line) {
+			rules = append(rules, i)
+		}
+	}
+	return rules
+}
+
+// recurringText returns the text of a recurring task's instances: the rule's
+// text without its ID and every: token.
+func recurringText(line string) string {
+	text := idPattern.ReplaceAllString(taskText(line), "")
+	return strings.Join(strings.Fields(everyPattern.ReplaceAllString(text, " ")), " ")
+}
+
+func listRecurring(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, i := range recurringRules(lines) {
+		rule := everyPattern.FindStringSubmatch(lines[i])[1]
+		text := strings.Join(strings.Fields(everyPattern.ReplaceAllString(taskText(lines[i]), " ")), " ")
+		fmt.Fprintf(w, "every %s\t%s\n", rule, text)
+	}
+	return w.Flush()
+}
+
+// runRecurring adds today's instances of the recurring tasks to the working
+// date's section. A task already in the section, whatever its marker, is not
+// added again, so it is safe to run repeatedly, such as from cron.
+func runRecurring(c *cli.Context) error {
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	section, err := sectionLines(lines, date)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, line := range section {
+		if isBullet(line) {
+			existing[recurringText(line)] = true
+		}
+	}
+
+	var tasks []string
+	for _, i := range recurringRules(lines) {
+		due, err := parseEvery(everyPattern.FindStringSubmatch(lines[i])[1])
+		if err != nil {
+			return parseError(fmt.Errorf("line %d: %v", bulletlog.LineNumber(lines, i), err))
+		}
+		text := recurringText(lines[i])
+		if due(date) && !existing[text] {
+			tasks = append(tasks, text)
+			existing[text] = true
+		}
+	}
+
+	for _, text := range tasks {
+		lines, err = insertBullet(lines, date, "", withID(lines, openMark+text))
+		if err != nil {
+			return err
+		}
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	fmt.Printf("Added %d tasks\n", len(tasks))
+	return nil
+}
+
+type habitStats struct {
+	Habit   string   `json:"habit"`
+	Current int      `json:"current"`
+	Longest int      `json:"longest"`
+	Days    []string `json:"days"`
+}
+
+// habitName returns the habit a `+` entry records, without its ID.
+func habitName(line string) string {
+	return strings.Join(strings.Fields(idPattern.ReplaceAllString(taskText(line), "")), " ")
+}
+
+// listHabits prints each habit's current and longest streak of consecutive
+// days, with a row marking the days of the last --days it was done. A
+// streak not yet extended today still counts as current until tomorrow.
+func listHabits(c *cli.Context) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	span := c.Int("days")
+	if span <= 0 {
+		return fmt.Errorf("Invalid number of days: %d", span)
+	}
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	sections, err := log.ListSections()
+	if err != nil {
+		return err
+	}
+
+	// Habits are matched ignoring case, and named as first written.
+	var names []string
+	days := map[string]map[string]bool{}
+	for _, section := range sections {
+		if section.Date == nil {
+			continue
+		}
+		for _, entry := range section.Entries {
+			line := entry.String()
+			if entryType(line) != "habit" {
+				continue
+			}
+			name := habitName(line)
+			key := strings.ToLower(name)
+			if days[key] == nil {
+				days[key] = map[string]bool{}
+				names = append(names, name)
+			}
+			days[key][section.Date.Format(dateFormat)] = true
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	habits := []habitStats{}
+	for _, name := range names {
+		done := days[strings.ToLower(name)]
+		stats := habitStats{Habit: name}
+		for day := range done {
+			stats.Days = append(stats.Days, day)
+		}
+		sort.Strings(stats.Days)
+
+		run := 0
+		var prev time.Time
+		for _, day := range stats.Days {
+			t, _ := time.Parse(dateFormat, day)
+			if run > 0 && t.Equal(prev.AddDate(0, 0, 1)) {
+				run++
+			} else {
+				run = 1
+			}
+			if run > stats.Longest {
+				stats.Longest = run
+			}
+			prev = t
+		}
+
+		t := today
+		if !done[t.Format(dateFormat)] {
+			t = t.AddDate(0, 0, -1)
+		}
+		for done[t.Format(dateFormat)] {
+			stats.Current++
+			t = t.AddDate(0, 0, -1)
+		}
+		habits = append(habits, stats)
+	}
+
+	if wantJSON(c) {
+		return writeJSON(out, habits)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(habits) > 0 {
+		fmt.Fprintf(w, "habit\tcurrent\tlongest\tsince %s\n", today.AddDate(0, 0, 1-span).Format(dateFormat))
+	}
+	for _, stats := range habits {
+		done := days[strings.ToLower(stats.Habit)]
+		var row strings.Builder
+		for t := today.AddDate(0, 0, 1-span); !t.After(today); t = t.AddDate(0, 0, 1) {
+			if done[t.Format(dateFormat)] {
+				row.WriteString("#")
+			} else {
+				row.WriteString(".")
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", stats.Habit, stats.Current, stats.Longest, row.String())
+	}
+	return w.Flush()
+}
+
+// heatmapCells are the cells of a heatmap from no entries to the most, and
+// heatmapColors their 256-color palette numbers when colored.
+var (
+	heatmapCells  = []string{"·", "░", "▒", "▓", "█"}
+	heatmapColors = []int{238, 22, 28, 34, 40}
+)
+
+// showHeatmap prints the number of entries per day of a year as a grid with
+// a column per week and a row per weekday, starting on Monday, shaded by how
+// busy the day was compared to the busiest.
+func showHeatmap(c *cli.Context) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	year := c.Int("year")
+	if year == 0 {
+		year = today.Year()
+	}
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	sections, err := log.ListSections()
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	most, total := 0, 0
+	for _, section := range sections {
+		if section.Date == nil || section.Date.Year() != year {
+			continue
+		}
+		key := section.Date.Format(dateFormat)
+		counts[key] += len(section.Entries)
+		total += len(section.Entries)
+		if counts[key] > most {
+			most = counts[key]
+		}
+	}
+
+	color := c.String("output") == "" && useColor(os.Stdout)
+	cell := func(level int) string {
+		if color {
+			return fmt.Sprintf("\x1b[38;5;%dm■\x1b[0m", heatmapColors[level])
+		}
+		return heatmapCells[level]
+	}
+
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC)
+	start := weekStart(first)
+
+	// Label each month above the week holding its first day, where it fits.
+	weeks := int(last.Sub(start).Hours()/24/7) + 1
+	months := []byte(strings.Repeat(" ", weeks+2))
+	free := 0
+	for w := 0; w < weeks; w++ {
+		end := start.AddDate(0, 0, 7*w+6)
+		if end.Day() <= 7 && end.Year() == year && w >= free {
+			copy(months[w:], end.Format("Jan"))
+			free = w + 4
+		}
+	}
+	fmt.Fprintf(out, "    %s\n", strings.TrimRight(string(months), " "))
+
+	for weekday := 0; weekday < 7; weekday++ {
+		label := ""
+		if weekday%2 == 0 {
+			label = time.Weekday((weekday + 1) % 7).String()[:3]
+		}
+		row := fmt.Sprintf("%-4s", label)
+		for t := start.AddDate(0, 0, weekday); !t.After(last); t = t.AddDate(0, 0, 7) {
+			if t.Before(first) {
+				row += " "
+				continue
+			}
+			level := 0
+			if n := counts[t.Format(dateFormat)]; n > 0 {
+				level = (4*n + most - 1) / most
+			}
+			row += cell(level)
+		}
+		fmt.Fprintln(out, row)
+	}
+
+	legend := make([]string, len(heatmapCells))
+	for level := range heatmapCells {
+		legend[level] = cell(level)
+	}
+	fmt.Fprintf(out, "\n%d entries on %d days in %d    less %s more\n", total, len(counts), year, strings.Join(legend, ""))
+	return nil
+}
+
+// showMonth prints the monthly log: a calendar of the month followed by the
+// tasks set aside for it in the future log and the open tasks due in it.
+func showMonth(c *cli.Context) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if arg := c.Args().First(); arg != "" {
+		month, err = parseMonth(arg, today)
+		if err != nil {
+			return err
+		}
+	}
+	last := month.AddDate(0, 1, -1)
+
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	color := c.String("output") == "" && useColor(os.Stdout)
+	title := month.Format("January 2006")
+	fmt.Fprintf(out, "%*s\n", (20+len(title))/2, title)
+	fmt.Fprintln(out, "Mo Tu We Th Fr Sa Su")
+	week := strings.Repeat("   ", (int(month.Weekday())+6)%7)
+	for d := month; !d.After(last); d = d.AddDate(0, 0, 1) {
+		day := fmt.Sprintf("%2d", d.Day())
+		if color && d.Equal(today) {
+			day = "\x1b[7m" + day + "\x1b[0m"
+		}
+		week += day
+		if d.Weekday() == time.Sunday || d.Equal(last) {
+			fmt.Fprintln(out, week)
+			week = ""
+		} else {
+			week += " "
+		}
+	}
+
+	var planned []string
+	for _, i := range futureItems(lines) {
+		if m, ok := getMonth(lines[i]); ok && m.Equal(month) {
+			planned = append(planned, withoutMonth(taskText(lines[i])))
+		}
+	}
+	due := dueTasks(lines, &month, last)
+	if ascending, ok, err := getOrder(c); err != nil {
+		return err
+	} else if ok && !ascending {
+		for i, j := 0, len(due)-1; i < j; i, j = i+1, j-1 {
+			due[i], due[j] = due[j], due[i]
+		}
+	}
+	if len(planned) == 0 && len(due) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Tasks")
+	for _, text := range planned {
+		fmt.Fprintf(out, "  %s\n", text)
+	}
+	for _, t := range due {
+		fmt.Fprintf(out, "  %s %d: %s\n", t.due.Format(dateFormat), t.number, t.text)
+	}
+	return nil
+}
+
+// listTags prints every tag in the log with the number of entries carrying
+// it, most used first.
+func listTags(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, line := range lines {
+		if !isBullet(line) {
+			continue
+		}
+		for _, tag := range getTags(taskText(line)) {
+			counts[tag] += 1
+		}
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if counts[tags[i]] != counts[tags[j]] {
+			return counts[tags[i]] > counts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+
+	if wantJSON(c) {
+		type tagCount struct {
+			Tag   string `json:"tag"`
+			Count int    `json:"count"`
+		}
+		result := []tagCount{}
+		for _, tag := range tags {
+			result = append(result, tagCount{tag, counts[tag]})
+		}
+		return writeJSON(out, result)
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(out, "#%s %d\n", tag, counts[tag])
+	}
+	return nil
+}
+
+// reportTags prints how often each tag was used per week over the last N
+// days, oldest week first.
+func reportTags(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	days := c.Int("last")
+	if days <= 0 {
+		return fmt.Errorf("Invalid number of days: %d", days)
+	}
+
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	since := today.AddDate(0, 0, -(days - 1))
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	var weeks []time.Time
+	for w := weekStart(since); !w.After(today); w = w.AddDate(0, 0, 7) {
+		weeks = append(weeks, w)
+	}
+
+	counts := map[string][]int{}
+	var date *time.Time
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			date = sectionDate(lines, i)
+			continue
+		}
+		if date == nil || date.Before(since) || date.After(today) || !isBullet(line) {
+			continue
+		}
+		week := int(date.Sub(weeks[0]).Hours()/24) / 7
+		for _, tag := range getTags(taskText(line)) {
+			if counts[tag] == nil {
+				counts[tag] = make([]int, len(weeks))
+			}
+			counts[tag][week] += 1
+		}
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	if wantJSON(c) {
+		report := struct {
+			Weeks []string         `json:"weeks"`
+			Tags  map[string][]int `json:"tags"`
+		}{[]string{}, counts}
+		for _, w := range weeks {
+			report.Weeks = append(report.Weeks, w.Format(dateFormat))
+		}
+		return json.NewEncoder(out).Encode(report)
+	}
+
+	if len(tags) == 0 {
+		fmt.Fprintf(out, "No tags in the last %d days\n", days)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "\t")
+	for _, week := range weeks {
+		fmt.Fprintf(w, "%s\t", week.Format(dateFormat))
+	}
+	fmt.Fprintln(w)
+	for _, tag := range tags {
+		fmt.Fprintf(w, "#%s\t", tag)
+		for _, n := range counts[tag] {
+			fmt.Fprintf(w, "%d\t", n)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+// reportWeekday prints how many tasks were completed, or with --all how many
+// entries were written, on each day of the week.
+func reportWeekday(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	since, until, err := getDateRange(c, "since", "until")
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	var counts [7]int
+	var date *time.Time
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			date = sectionDate(lines, i)
+			continue
+		}
+		if date == nil || !inRange(*date, since, until) {
+			continue
+		}
+		counted := strings.HasPrefix(line, doneMark)
+		if c.Bool("all") {
+			counted = isBullet(line)
+		}
+		if counted {
+			counts[date.Weekday()] += 1
+		}
+	}
+
+	// Weeks start on Monday, as in weekStart.
+	days := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+	if wantJSON(c) {
+		report := map[string]int{}
+		for _, d := range days {
+			report[d.String()] = counts[d]
+		}
+		return json.NewEncoder(out).Encode(report)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, d := range days {
+		fmt.Fprintf(w, "%s\t%d\n", d, counts[d])
+	}
+	return w.Flush()
+}
+
+type taskCount struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+type dayStats struct {
+	Date      string `json:"date"`
+	Entries   int    `json:"entries"`
+	Open      int    `json:"open"`
+	Done      int    `json:"done"`
+	Cancelled int    `json:"cancelled"`
+}
+
+type weekStats struct {
+	Week  string  `json:"week"`
+	Done  int     `json:"done"`
+	Total int     `json:"total"`
+	Rate  float64 `json:"rate"`
+}
+
+type statsReport struct {
+	Since       *string        `json:"since"`
+	Until       *string        `json:"until"`
+	Open        int            `json:"open"`
+	Done        int            `json:"done"`
+	Cancelled   int            `json:"cancelled"`
+	Total       int            `json:"total"`
+	Types       map[string]int `json:"types"`
+	Statuses    map[string]int `json:"statuses"`
+	TasksPerDay float64        `json:"tasks_per_day"`
+	Days        []dayStats     `json:"days"`
+	Weeks       []weekStats    `json:"weeks"`
+	Busiest     []dayStats     `json:"busiest"`
+}
+
+// busiestDays is how many days stats lists as the busiest.
+const busiestDays = 5
+
+// showStats prints how many tasks are completed out of all open and completed
+// tasks, followed by the entries per type and task status, the tasks logged
+// per day, the completion rate per week starting on Monday, and the days with
+// the most entries. With --per-tag it prints the completed tasks for each tag
+// instead; a task with several tags counts toward each of them. With --since
+// or --until only the date sections in that range are counted.
+func showStats(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	since, until, err := getDateRange(c, "since", "until")
+	if err != nil {
+		return err
+	}
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	sections, err := log.ListSections()
+	if err != nil {
+		return err
+	}
+
+	const untagged = "(untagged)"
+
+	report := statsReport{
+		Types:    map[string]int{},
+		Statuses: map[string]int{},
+		Days:     []dayStats{},
+		Weeks:    []weekStats{},
+	}
+	if since != nil {
+		s := since.Format(dateFormat)
+		report.Since = &s
+	}
+	if until != nil {
+		s := until.Format(dateFormat)
+		report.Until = &s
+	}
+
+	var total taskCount
+	perTag := map[string]*taskCount{}
+	days := map[string]*dayStats{}
+	weeks := map[string]*taskCount{}
+	for _, section := range sections {
+		if (since != nil || until != nil) && (section.Date == nil || !inRange(*section.Date, since, until)) {
+			continue
+		}
+
+		var day *dayStats
+		var week *taskCount
+		if section.Date != nil {
+			key := section.Date.Format(dateFormat)
+			if days[key] == nil {
+				days[key] = &dayStats{Date: key}
+			}
+			day = days[key]
+			key = weekStart(*section.Date).Format(dateFormat)
+			if weeks[key] == nil {
+				weeks[key] = &taskCount{}
+			}
+			week = weeks[key]
+		}
+
+		for _, entry := range section.Entries {
+			line := entry.String()
+			typ := entryType(line)
+			report.Types[typ] += 1
+			if day != nil {
+				day.Entries += 1
+			}
+			if typ != "task" {
+				continue
+			}
+			report.Statuses[taskStatus(line)] += 1
+
+			if strings.HasPrefix(line, cancelledMark) {
+				report.Cancelled += 1
+				if day != nil {
+					day.Cancelled += 1
+				}
+				continue
+			}
+			done := strings.HasPrefix(line, doneMark)
+			if !done && !isOpenTask(line) {
+				continue
+			}
+			if day != nil {
+				if done {
+					day.Done += 1
+				} else {
+					day.Open += 1
+				}
+			}
+
+			tags := getTags(taskText(line))
+			if len(tags) == 0 {
+				tags = []string{untagged}
+			}
+			counts := []*taskCount{&total}
+			if week != nil {
+				counts = append(counts, week)
+			}
+			for _, tag := range tags {
+				if perTag[tag] == nil {
+					perTag[tag] = &taskCount{}
+				}
+				counts = append(counts, perTag[tag])
+			}
+			for _, count := range counts {
+				count.Total += 1
+				if done {
+					count.Done += 1
+				}
+			}
+		}
+	}
+
+	if c.Bool("per-tag") {
+		if wantJSON(c) {
+			return json.NewEncoder(out).Encode(perTag)
+		}
+
+		tags := make([]string, 0, len(perTag))
+		for tag := range perTag {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			name := tag
+			if tag != untagged {
+				name = "#" + tag
+			}
+			fmt.Fprintf(out, "%s: %d/%d\n", name, perTag[tag].Done, perTag[tag].Total)
+		}
+		return nil
+	}
+
+	report.Done = total.Done
+	report.Open = total.Total - total.Done
+	report.Total = total.Total
+	tasks := 0
+	for _, day := range days {
+		report.Days = append(report.Days, *day)
+		tasks += day.Open + day.Done + day.Cancelled
+	}
+	sort.Slice(report.Days, func(i, j int) bool {
+		return report.Days[i].Date < report.Days[j].Date
+	})
+	if len(days) > 0 {
+		report.TasksPerDay = float64(tasks) / float64(len(days))
+	}
+	for key, week := range weeks {
+		stats := weekStats{Week: key, Done: week.Done, Total: week.Total}
+		if week.Total > 0 {
+			stats.Rate = float64(week.Done) / float64(week.Total)
+		}
+		report.Weeks = append(report.Weeks, stats)
+	}
+	sort.Slice(report.Weeks, func(i, j int) bool {
+		return report.Weeks[i].Week < report.Weeks[j].Week
+	})
+	report.Busiest = append([]dayStats{}, report.Days...)
+	sort.SliceStable(report.Busiest, func(i, j int) bool {
+		return report.Busiest[i].Entries > report.Busiest[j].Entries
+	})
+	if len(report.Busiest) > busiestDays {
+		report.Busiest = report.Busiest[:busiestDays]
+	}
+
+	if wantJSON(c) {
+		return json.NewEncoder(out).Encode(report)
+	}
+
+	fmt.Fprintf(out, "%d/%d tasks completed\n", total.Done, total.Total)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w)
+	for _, typ := range []string{"note", "event", "habit", "task"} {
+		fmt.Fprintf(w, "%ss\t%d\n", typ, report.Types[typ])
+	}
+	statuses := make([]string, 0, len(report.Statuses))
+	for status := range report.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "  %s\t%d\n", status, report.Statuses[status])
+	}
+	if len(days) > 0 {
+		fmt.Fprintf(w, "\n%.1f tasks per day over %d days\n", report.TasksPerDay, len(days))
+	}
+	if len(report.Weeks) > 0 {
+		fmt.Fprintln(w, "\nweek of\tdone\trate")
+		for _, week := range report.Weeks {
+			fmt.Fprintf(w, "%s\t%d/%d\t%.0f%%\n", week.Week, week.Done, week.Total, 100*week.Rate)
+		}
+	}
+	if len(report.Busiest) > 0 {
+		fmt.Fprintln(w, "\nbusiest days\tentries")
+		for _, day := range report.Busiest {
+			fmt.Fprintf(w, "%s\t%d\n", day.Date, day.Entries)
+		}
+	}
+	return w.Flush()
+}
+
+// purgeEmptySections removes date sections without any entries.
+func purgeEmptySections(c *cli.Context) error {
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		if !bulletlog.IsHeader(lines[i]) {
+			kept = append(kept, lines[i])
+			continue
+		}
+		j := i + 1
+		empty := true
+		for ; j < len(lines) && !bulletlog.IsHeader(lines[j]); j++ {
+			if isBullet(lines[j]) {
+				empty = false
+			}
+		}
+		if empty && sectionDate(lines, i) != nil {
+			removed += 1
+		} else {
+			kept = append(kept, lines[i:j]...)
+		}
+		i = j - 1
+	}
+
+	if removed > 0 {
+		if err := log.Save(kept); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Removed %d empty sections\n", removed)
+	return nil
+}
+
+// getDailyGoal returns the number of tasks to complete each day set by
+// BULLETLOG_DAILY_GOAL, or 0 when no goal is set.
+func getDailyGoal() (int, error) {
+	goal, ok := lookupSetting("BULLETLOG_DAILY_GOAL")
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(goal)
+}
+
+// completedOn returns how many of the done tasks in lines, the log at path,
+// were completed on date. The journal tells when a task was completed; a
+// task it has no record of counts on the day of its section.
+func completedOn(path string, lines []string, date time.Time) (int, error) {
+	changes, err := readChanges(journalPath(path))
+	if err != nil {
+		return 0, err
+	}
+	day := date.Format(dateFormat)
+	completed := map[string]string{}
+	for _, ch := range changes {
+		open := map[string]bool{}
+		for _, line := range ch.Removed {
+			if isListedTask(line) {
+				open[entryKey(taskText(line))] = true
+			}
+		}
+		for _, line := range ch.Added {
+			if key := entryKey(taskText(line)); strings.HasPrefix(line, doneMark) && open[key] {
+				completed[key] = ch.Time.Local().Format(dateFormat)
+			}
+		}
+	}
+
+	count := 0
+	for i, line := range lines {
+		if !strings.HasPrefix(line, doneMark) {
+			continue
+		}
+		on, ok := completed[entryKey(taskText(line))]
+		if !ok {
+			if t := sectionDate(lines, i); t != nil {
+				on = t.Format(dateFormat)
+			}
+		}
+		if on == day {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// goalProgress describes how far the tasks completed on date go toward the
+// daily goal, or how many there are when no goal is set.
+func goalProgress(path string, lines []string, date time.Time) (string, error) {
+	goal, err := getDailyGoal()
+	if err != nil {
+		return "", err
+	}
+	done, err := completedOn(path, lines, date)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case goal <= 0:
+		return fmt.Sprintf("%d completed", done), nil
+	case done >= goal:
+		return fmt.Sprintf("%d/%d toward goal 🎉", done, goal), nil
+	default:
+		return fmt.Sprintf("%d/%d toward goal", done, goal), nil
+	}
+}
+
+func showStatus(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	date, err := getDate()
+	if err != nil {
+		return err
+	}
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	log, err := newStore(c, path, c.Command.FullName())
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	progress, err := goalProgress(path, lines, date)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: %s\n", date.Format(dateFormat), progress)
+	return nil
+}
+
+// orderFlag reorders the date sections a view prints, leaving the log as it
+// is.
+var orderFlag = &cli.StringFlag{
+	Name:  "order",
+	Usage: "print date sections in `ORDER`: asc, oldest first, or desc, newest first (default: the order of the log)",
+}
+
+// getOrder returns whether --order asks for ascending sections, and whether
+// it was given at all.
+func getOrder(c *cli.Context) (bool, bool, error) {
+	switch order := c.String("order"); order {
+	case "":
+		return false, false, nil
+	case "asc", "desc":
+		return order == "asc", true, nil
+	default:
+		return false, false, fmt.Errorf("Unknown order: %s; use asc or desc", order)
+	}
+}
+
+// orderSections returns lines with their date sections sorted by date,
+// oldest first when ascending, along with the index in lines of each line
+// returned. The other sections keep their places.
+func orderSections(lines []string, ascending bool) ([]string, []int, error) {
+	sections, err := newLog(lines).Sections()
+	if err != nil {
+		return nil, nil, parseError(err)
+	}
+	var dated []bulletlog.Section
+	for _, s := range sections {
+		if s.Date != nil {
+			dated = append(dated, s)
+		}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		if ascending {
+			return dated[i].Date.Before(*dated[j].Date)
+		}
+		return dated[i].Date.After(*dated[j].Date)
+	})
+
+	ordered := make([]string, 0, len(lines))
+	indexes := make([]int, 0, len(lines))
+	add := func(start, end int) {
+		for i := start; i < end; i++ {
+			ordered = append(ordered, lines[i])
+			indexes = append(indexes, i)
+		}
+	}
+	if len(sections) == 0 {
+		add(0, len(lines))
+		return ordered, indexes, nil
+	}
+	add(0, sections[0].Start)
+	n := 0
+	for _, s := range sections {
+		if s.Date != nil {
+			s = dated[n]
+			n++
+		}
+		// The last section of the log may end without a blank line.
+		if k := len(ordered); k > 0 && strings.TrimSpace(ordered[k-1]) != "" && bulletlog.IsHeader(lines[s.Start]) {
+			ordered = append(ordered, "")
+			indexes = append(indexes, -1)
+		}
+		add(s.Start, s.End)
+	}
+	return ordered, indexes, nil
+}
+
+// showWeek prints the sections of the week of the working date, Monday to
+// Sunday.
+func showWeek(c *cli.Context) error {
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+	start := weekStart(date)
+	return showSections(c, start, start.AddDate(0, 0, 6))
+}
+
+// showRecent prints the sections of the last N days, the working date
+// included; a week by default.
+func showRecent(c *cli.Context) error {
+	days := 7
+	if arg := c.Args().First(); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return fmt.Errorf("Invalid number of days: %q", arg)
+		}
+		days = n
+	}
+	date, err := getWorkingDate(c)
+	if err != nil {
+		return err
+	}
+	return showSections(c, date.AddDate(0, 0, -(days-1)), date)
+}
+
+// showSections prints the date sections from from to to, as show does a
+// single one.
+func showSections(c *cli.Context, from time.Time, to time.Time) error {
+	ascending, ordered, err := getOrder(c)
+	if err != nil {
+		return err
+	}
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+	sections, err := newLog(lines).Sections()
+	if err != nil {
+		return parseError(err)
+	}
+	numbers := bulletlog.LineNumbers(lines)
+
+	var shown []string
+	var indexes []int
+	for _, s := range sections {
+		if s.Date == nil || !inRange(*s.Date, &from, &to) {
+			continue
+		}
+		for i := s.Start; i < s.End; i++ {
+			shown = append(shown, lines[i])
+			indexes = append(indexes, i)
+		}
+	}
+	if ordered {
+		var order []int
+		if shown, order, err = orderSections(shown, ascending); err != nil {
+			return err
+		}
+		for j, k := range order {
+			if k >= 0 {
+				order[j] = indexes[k]
+			}
+		}
+		indexes = order
+	}
+
+	if wantJSON(c) {
+		entries := []jsonEntry{}
+		for j, line := range shown {
+			if !isBullet(line) {
+				continue
+			}
+			entries = append(entries, newJSONEntry(line, numbers[indexes[j]], sectionDate(shown, j)))
+		}
+		return writeJSON(out, entries)
+	}
+	for _, line := range shown {
+		fmt.Fprintln(out, line)
+	}
+	return nil
+}
+
+// normalizeLog strips the differences editors and sync tools tend to
+// introduce without changing the content: CRLF line endings, trailing
+// whitespace and trailing blank lines.
+func normalizeLog(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.Write(bytes.TrimRight(line, " \t\r"))
+		buf.WriteByte('\n')
+	}
+	return append(bytes.TrimRight(buf.Bytes(), "\n"), '\n')
+}
+
+func checksum(c *cli.Context) error {
+	out, err := getOutput(c)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out)
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if c.Bool("raw") {
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return ioError(err)
+		}
+	} else {
+		r, err := openLog(path)
+		if err != nil {
+			return err
+		}
+		data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		data = normalizeLog(data)
+	}
+	fmt.Fprintf(out, "%x\n", sha256.Sum256(data))
+	return nil
+}
+
+// getAliases parses BULLETLOG_ALIASES, a semicolon-separated list of
+// `name=command args` pairs such as "todo=tasks --age;ideas=notes --times".
+func getAliases() (map[string][]string, error) {
+	aliases := map[string][]string{}
+	value, ok := lookupSetting("BULLETLOG_ALIASES")
+	if !ok {
+		return aliases, nil
+	}
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		f := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(f[0])
+		if len(f) != 2 || name == "" || len(strings.Fields(f[1])) == 0 {
+			return nil, fmt.Errorf("Invalid alias: %q", pair)
+		}
+		aliases[name] = strings.Fields(f[1])
+	}
+	return aliases, nil
+}
+
+// registerAliases adds a command to app for each user alias. Running an alias
+// runs its expansion with the global flags given before it and the remaining
+// arguments appended, so with todo=tasks --age, `blt --json todo --sort age`
+// runs `blt --json tasks --age --sort age`.
+// Expansions are split on whitespace, without quoting, and must start with a
+// built-in command: aliases never expand to other aliases.
+func registerAliases(app *cli.App, aliases map[string][]string) error {
+	builtin := func(name string) bool {
+		if name == "help" || name == "h" {
+			return true
+		}
+		for _, cmd := range app.Commands {
+			if cmd.HasName(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var commands []*cli.Command
+	for _, name := range names {
+		expansion := aliases[name]
+		if builtin(name) {
+			return fmt.Errorf("Alias %s collides with a built-in command", name)
+		}
+		if !builtin(expansion[0]) {
+			return fmt.Errorf("Alias %s expands to unknown command %s", name, expansion[0])
+		}
+		commands = append(commands, &cli.Command{
+			Name:            name,
+			Usage:           fmt.Sprintf("Alias for %s", strings.Join(expansion, " ")),
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				args := append([]string{app.Name}, globalArgs(c, app.Flags)...)
+				args = append(args, expansion...)
+				return app.Run(append(args, c.Args().Slice()...))
+			},
+		})
+	}
+	app.Commands = append(app.Commands, commands...)
+	return nil
+}
+
+// globalArgs returns the flags of flags set in c as arguments, to be given
+// again. -C is left out, since blt has already moved to its directory.
+func globalArgs(c *cli.Context, flags []cli.Flag) []string {
+	var args []string
+	for _, f := range flags {
+		name := f.Names()[0]
+		if name == "working-dir" || !c.IsSet(name) {
+			continue
+		}
+		if _, isBool := f.(*cli.BoolFlag); isBool {
+			args = append(args, "--"+name)
+		} else {
+			args = append(args, "--"+name, c.String(name))
+		}
+	}
+	return args
+}
+
+var dryRunFlag = &cli.BoolFlag{
+	Name:    "dry-run",
+	Aliases: []string{"n"},
+	Usage:   "print a diff of the changes instead of writing them",
+}
+
+var addDateFlag = &cli.StringFlag{
+	Name:    "date",
+	Aliases: []string{"d"},
+	Usage:   "file the bullet under `DATE` instead of today",
+}
+
+var timeFlag = &cli.BoolFlag{
+	Name:    "time",
+	Aliases: []string{"T"},
+	Usage:   "file the bullet under the current hour's ### HH:MM sub-section",
+}
+
+var addFlags = []cli.Flag{
+	dryRunFlag,
+	addDateFlag,
+	timeFlag,
+	&cli.BoolFlag{
+		Name:    "editor",
+		Aliases: []string{"e"},
+		Usage:   "write the text in $EDITOR, starting from the arguments or the file entry_template names",
+	},
+	&cli.BoolFlag{
+		Name:  "multiline",
+		Usage: "read the text from stdin up to EOF, its lines after the first becoming continuation lines",
+	},
+	&cli.BoolFlag{
+		Name:    "priority",
+		Aliases: []string{"p"},
+		Usage:   "flag the bullet as a priority with !",
+	},
+	&cli.BoolFlag{
+		Name:  "question",
+		Usage: "flag the bullet as a question or inspiration with ?",
+	},
+	&cli.StringFlag{
+		Name:  "to",
+		Usage: "add the bullet to the collection `NAME` instead of a day",
+	},
+}
+
+var listFlags = []cli.Flag{
+	outputFlag,
+	&cli.BoolFlag{
+		Name:  "times",
+		Usage: "prefix bullets with their ### HH:MM sub-section time",
+	},
+	&cli.BoolFlag{
+		Name:  "glyphs",
+		Usage: "render markers as glyphs when writing to a terminal",
+	},
+	&cli.BoolFlag{
+		Name:  "priority",
+		Usage: "list only bullets flagged as a priority",
+	},
+	&cli.StringFlag{
+		Name:  "tag",
+		Usage: "list only bullets tagged #`TAG`",
+	},
+	&cli.StringFlag{
+		Name:  "since",
+		Usage: "list only sections from `DATE` on",
+	},
+	&cli.StringFlag{
+		Name:  "until",
+		Usage: "list only sections up to `DATE`",
+	},
+	&cli.IntFlag{
+		Name:  "last",
+		Usage: "list only the last `N` days, today included",
+	},
+}
+
+var rangeFlags = []cli.Flag{
+	dryRunFlag,
+	&cli.StringFlag{
+		Name:  "from",
+		Usage: "only entries on or after `DATE`",
+	},
+	&cli.StringFlag{
+		Name:  "to",
+		Usage: "only entries on or before `DATE`",
+	},
+}
+
+var outputFlag = &cli.StringFlag{
+	Name:    "output",
+	Aliases: []string{"o"},
+	Usage:   "write to `FILE` instead of stdout",
+}
+
+// reverseFlag numbers tasks from the oldest up. Every command taking a task
+// number accepts it, so numbers from `tasks --reverse` can be reused.
+var reverseFlag = &cli.BoolFlag{
+	Name:    "reverse",
+	Aliases: []string{"r"},
+	Usage:   "number tasks from the bottom of the file, oldest first",
+}
+
+// viewFlags are the flags of the commands showing a run of sections.
+var viewFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "date",
+		Aliases: []string{"d"},
+		Usage:   "show the days up to `DATE` instead of today",
+	},
+	orderFlag,
+	outputFlag,
+}
+
+var taskListFlags = append([]cli.Flag{
+	reverseFlag,
+	&cli.BoolFlag{
+		Name:  "summary",
+		Usage: "end with a count of tasks by status",
+	},
+	&cli.IntFlag{
+		Name:  "stale",
+		Usage: "flag tasks older than `N` days",
+	},
+	&cli.BoolFlag{
+		Name:  "only-stale",
+		Usage: "list only stale tasks (older than 7 days unless --stale is given)",
+	},
+	&cli.BoolFlag{
+		Name:  "age",
+		Usage: "annotate tasks with their age in days",
+	},
+	&cli.BoolFlag{
+		Name:  "cancelled",
+		Usage: "also list cancelled tasks, after the open ones",
+	},
+	&cli.StringFlag{
+		Name:  "sort",
+		Usage: "order tasks by `ORDER` (age) instead of file order",
+	},
+	&cli.StringFlag{
+		Name:  "status",
+		Usage: "list only tasks in `STATUS`: open, whose marker is one of open_markers (only - by default), or in-progress (/); tasks in progress are listed and numbered either way",
+	},
+}, listFlags...)
+
+func main() {
+	app := &cli.App{
+		Name:  "blt",
+		Usage: "Take a log quickly like bullets.",
+		Description: "blt exits with status 1 when search finds nothing, 2 when the log cannot\n" +
+			"   be read or written, 3 when the log is malformed, and 4 on usage errors.\n\n" +
+			"   Wherever a DATE is taken it may be YYYYMMDD, YYYY-MM-DD, today, yesterday,\n" +
+			"   tomorrow, an offset such as -2d, +1w, +3m or -1y, or a weekday such as\n" +
+			"   friday, \"next monday\" or \"last tue\".",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "working-dir",
+				Aliases: []string{"C"},
+				Usage:   "run as if blt was started in `DIR`",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print entries as JSON from notes, events, tasks, search, show, today and tags",
+			},
+			&cli.StringFlag{
+				Name:    "book",
+				Aliases: []string{"b"},
+				Usage:   "use the log of the notebook called `NAME` in config.toml",
+			},
+			&cli.BoolFlag{
+				Name:  "commit",
+				Usage: "commit changes to the log to the git repository holding it",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			// Relative log paths are resolved against the new directory.
+			if dir := c.String("working-dir"); dir != "" {
+				if err := os.Chdir(dir); err != nil {
+					return err
+				}
+			}
+			// An alias runs the app again; leave book alone unless given.
+			if c.IsSet("book") {
+				book = c.String("book")
+			}
+			return nil
+		},
+		After: func(c *cli.Context) error {
+			releaseLocks()
+			return nil
+		},
+		Commands: []*cli.Command{
+			{
+				Name:   "init",
+				Usage:  "Create a log for the current directory and those below it",
+				Action: initLog,
+			},
+			{
+				Name:      "add",
+				Aliases:   []string{"a", "note"},
+				Usage:     "Add a note",
+				ArgsUsage: "[text... | -]",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "stdin-json",
+						Usage: "add a JSON array of {type, text, date} objects read from stdin",
+					},
+					&cli.StringFlag{
+						Name:  "attach",
+						Usage: "reference `FILE` from the note, to be opened with blt open",
+					},
+				}, addFlags...),
+				Action: addNote,
+			},
+			{
+				Name:      "task",
+				Aliases:   []string{"t"},
+				Usage:     "Add a task",
+				ArgsUsage: "[text... | -]",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "due",
+						Usage: "make the task due on `DATE`",
+					},
+					&cli.StringFlag{
+						Name:  "every",
+						Usage: "make the task recur every `RULE`: day, weekday, weekdays such as mon,thu, or a day of the month",
+					},
+				}, addFlags...),
+				Action: addTask,
+			},
+			{
+				Name:      "event",
+				Aliases:   []string{"e"},
+				Usage:     "Add an event",
+				ArgsUsage: "[text... | -]",
+				Flags:     addFlags,
+				Action:    addEvent,
+			},
+			{
+				Name:   "habit",
+				Usage:  "Record a habit as done today",
+				Flags:  []cli.Flag{dryRunFlag, addDateFlag, timeFlag},
+				Action: addHabit,
+			},
+			{
+				Name:  "habits",
+				Usage: "Show the current and longest streak of each habit",
+				Flags: []cli.Flag{
+					outputFlag,
+					&cli.IntFlag{
+						Name:  "days",
+						Value: 30,
+						Usage: "mark the last `N` days in each habit's row",
+					},
+				},
+				Action: listHabits,
+			},
+			{
+				Name:  "heatmap",
+				Usage: "Show entries per day over a year as a heatmap",
+				Flags: []cli.Flag{
+					outputFlag,
+					&cli.IntFlag{
+						Name:  "year",
+						Usage: "show `YEAR` instead of the current one",
+					},
+				},
+				Action: showHeatmap,
+			},
+			{
+				Name:  "export",
+				Usage: "Write the log in another format",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Value:   "markdown",
+						Usage:   "write the log as `FORMAT`: " + strings.Join(exportFormats(), ", "),
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "only sections on or after `DATE`",
+					},
+					&cli.StringFlag{
+						Name:  "until",
+						Usage: "only sections on or before `DATE`",
+					},
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "only entries of `TYPE`: note, task, event or habit",
+					},
+					outputFlag,
+				},
+				Action: exportLog,
+			},
+			{
+				Name:   "undo",
+				Usage:  "Take back the last change to the log",
+				Flags:  []cli.Flag{dryRunFlag},
+				Action: undo,
+			},
+			{
+				Name:   "redo",
+				Usage:  "Make the last undone change again",
+				Flags:  []cli.Flag{dryRunFlag},
+				Action: redo,
 			},
 			{
-				Name:    "task",
-				Aliases: []string{"t"},
-				Usage:   "Add a task",
-				Action:  addTask,
+				Name:  "history",
+				Usage: "List the changes made to the log",
+				Flags: []cli.Flag{
+					outputFlag,
+					&cli.IntFlag{
+						Name:  "last",
+						Usage: "list only the last `N` changes",
+					},
+				},
+				Action: showHistory,
+			},
+			{
+				Name:      "convert",
+				Usage:     "Copy the log into a new log kept in another storage",
+				ArgsUsage: "PATH",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "storage",
+						Usage:    "keep the new log in `STORAGE`: " + strings.Join(storageNames(), ", "),
+						Required: true,
+					},
+				},
+				Action: convertLog,
+			},
+			{
+				Name:  "backup",
+				Usage: "Back up the log",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "list",
+						Aliases: []string{"l"},
+						Usage:   "list the backups instead",
+					},
+				},
+				Action: backupCommand,
+			},
+			{
+				Name:  "restore",
+				Usage: "Restore the log from its latest backup",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "at",
+						Usage: "restore the latest backup taken at or before `TIME` (a backup's name, YYYYMMDDTHHMM, or a date)",
+					},
+					dryRunFlag,
+				},
+				Action: restoreLog,
+			},
+			{
+				Name:   "sync",
+				Usage:  "Commit the log to git, then pull and push its repository, or merge it with a remote log",
+				Action: syncLog,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "remote",
+						Usage: "merge with the log at `PATH|URL` instead of using git",
+					},
+					&cli.StringFlag{
+						Name:  "prefer",
+						Value: "newer",
+						Usage: "keep an entry changed on both sides as `SIDE` has it: newer, local or remote",
+					},
+					dryRunFlag,
+				},
+			},
+			{
+				Name:   "serve",
+				Usage:  "Serve the log over HTTP as a JSON API, to clients with the token set in config.toml",
+				Action: serveLog,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: "localhost:8080",
+						Usage: "listen on `ADDR`",
+					},
+					&cli.BoolFlag{
+						Name:  "sync",
+						Usage: "serve the log at /sync for blt sync --remote URL to merge with",
+					},
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "Add the entries of a file in another format",
+				ArgsUsage: "[FILE]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "format",
+						Aliases:  []string{"f"},
+						Required: true,
+						Usage:    "read FILE as `FORMAT`: " + strings.Join(importFormats(), ", "),
+					},
+					&cli.StringFlag{
+						Name:    "date",
+						Aliases: []string{"d"},
+						Usage:   "file undated entries under `DATE` instead of today",
+					},
+					dryRunFlag,
+				},
+				Action: importLog,
+			},
+			{
+				Name:      "merge",
+				Usage:     "Merge another log into the log by date, leaving out the entries it already has",
+				ArgsUsage: "FILE",
+				Flags:     []cli.Flag{dryRunFlag},
+				Action:    mergeLog,
 			},
 			{
 				Name:    "notes",
 				Aliases: []string{"ls"},
 				Usage:   "List notes",
+				Flags:   listFlags,
 				Action:  listNotes,
 			},
+			{
+				Name:   "events",
+				Usage:  "List events",
+				Flags:  listFlags,
+				Action: listEvents,
+			},
 			{
 				Name:    "tasks",
 				Aliases: []string{"ts"},
 				Usage:   "List tasks",
+				Flags:   taskListFlags,
 				Action:  listTasks,
 			},
 			{
-				Name:    "complete",
-				Aliases: []string{"comp"},
-				Usage:   "Complete task",
-				Action:  completeTask,
+				Name:      "complete",
+				Aliases:   []string{"comp"},
+				Usage:     "Complete task, or pick tasks to complete from a list",
+				ArgsUsage: "[task number | id:N]",
+				Flags: []cli.Flag{
+					reverseFlag,
+					dryRunFlag,
+					&cli.IntFlag{
+						Name:  "line",
+						Usage: "complete the task on line `N` of the log instead",
+					},
+					&cli.BoolFlag{
+						Name:  "stdin",
+						Usage: "complete every task number read from stdin",
+					},
+				},
+				Action: completeTask,
+			},
+			{
+				Name:  "ensure-today",
+				Usage: "Create today's section if it does not exist",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "date",
+						Aliases: []string{"d"},
+						Usage:   "use `DATE` instead of today",
+					},
+					dryRunFlag,
+				},
+				Action: ensureToday,
+			},
+			{
+				Name:      "move-section",
+				Usage:     "Move a date section to its chronological position",
+				ArgsUsage: "DATE",
+				Flags:     []cli.Flag{dryRunFlag},
+				Action:    moveSection,
+			},
+			{
+				Name:   "purge-empty-sections",
+				Usage:  "Remove date sections without entries",
+				Flags:  []cli.Flag{dryRunFlag},
+				Action: purgeEmptySections,
+			},
+			{
+				Name:   "lint",
+				Usage:  "Report suspicious entries",
+				Flags:  []cli.Flag{outputFlag},
+				Action: lint,
+			},
+			{
+				Name:  "tag",
+				Usage: "Tag or untag entries in a date range",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "add",
+						Usage:     "Append a tag to every entry in the range",
+						ArgsUsage: "TAG",
+						Flags:     rangeFlags,
+						Action:    addTag,
+					},
+					{
+						Name:      "remove",
+						Usage:     "Remove a tag from every entry in the range",
+						ArgsUsage: "TAG",
+						Flags:     rangeFlags,
+						Action:    deleteTag,
+					},
+				},
+			},
+			{
+				Name:      "search",
+				Usage:     "Search entries",
+				ArgsUsage: "<query>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "regex",
+						Usage: "treat the query as a regular expression",
+					},
+					&cli.BoolFlag{
+						Name:    "ignore-case",
+						Aliases: []string{"i"},
+						Usage:   "match case-insensitively",
+					},
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "search only entries of `TYPE` (task, note, event or habit)",
+					},
+					&cli.BoolFlag{
+						Name:    "quiet",
+						Aliases: []string{"q"},
+						Usage:   "print nothing; only exit 1 when there is no match",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "search the archives too",
+					},
+					outputFlag,
+				},
+				Action: search,
+			},
+			{
+				Name:   "books",
+				Usage:  "List notebooks",
+				Action: listBooks,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "add",
+						Usage:     "Add a notebook to config.toml",
+						ArgsUsage: "<name> <path>",
+						Action:    addBook,
+					},
+					{
+						Name:      "remove",
+						Usage:     "Remove a notebook from config.toml",
+						ArgsUsage: "<name>",
+						Action:    removeBook,
+					},
+				},
+			},
+			{
+				Name:    "collections",
+				Aliases: []string{"collection"},
+				Usage:   "List collections",
+				Flags:   []cli.Flag{outputFlag},
+				Action:  listCollections,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "create",
+						Usage:     "Add an empty collection to the log",
+						ArgsUsage: "<name>",
+						Flags:     []cli.Flag{dryRunFlag},
+						Action:    createCollection,
+					},
+					{
+						Name:      "show",
+						Usage:     "List the entries of a collection",
+						ArgsUsage: "<name>",
+						Flags:     []cli.Flag{outputFlag},
+						Action:    showCollection,
+					},
+				},
+			},
+			{
+				Name:   "agenda",
+				Usage:  "List open tasks due this week, from today on",
+				Flags:  []cli.Flag{outputFlag},
+				Action: showAgenda,
+			},
+			{
+				Name:   "overdue",
+				Usage:  "List open tasks that are past due",
+				Flags:  []cli.Flag{outputFlag},
+				Action: showOverdue,
+			},
+			{
+				Name:      "future",
+				Usage:     "Add a task to the future log, or list the future log",
+				ArgsUsage: "[\"MONTH: task\"]",
+				Flags:     []cli.Flag{outputFlag, dryRunFlag},
+				Action:    future,
+			},
+			{
+				Name:   "recur",
+				Usage:  "List recurring tasks",
+				Flags:  []cli.Flag{outputFlag},
+				Action: listRecurring,
+				Subcommands: []*cli.Command{
+					{
+						Name:  "run",
+						Usage: "Add today's recurring tasks that are not in today's section yet",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "date",
+								Aliases: []string{"d"},
+								Usage:   "add the tasks due on `DATE` instead of today",
+							},
+							dryRunFlag,
+						},
+						Action: runRecurring,
+					},
+				},
+			},
+			{
+				Name:      "month",
+				Usage:     "Show the monthly log: a calendar with the month's tasks",
+				ArgsUsage: "[MONTH]",
+				Flags:     []cli.Flag{orderFlag, outputFlag},
+				Action:    showMonth,
+			},
+			{
+				Name:   "tags",
+				Usage:  "List tags with how many entries carry them",
+				Flags:  []cli.Flag{outputFlag},
+				Action: listTags,
+			},
+			{
+				Name:   "reindex",
+				Usage:  "Give every entry a stable ID and rebuild the search index",
+				Flags:  []cli.Flag{dryRunFlag},
+				Action: reindex,
+			},
+			{
+				Name:  "report",
+				Usage: "Summarize the log",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "tags",
+						Usage: "Show tag usage per week",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "last",
+								Value: 90,
+								Usage: "cover the last `N` days",
+							},
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "print the report as JSON",
+							},
+							outputFlag,
+						},
+						Action: reportTags,
+					},
+					{
+						Name:  "weekday",
+						Usage: "Show completed tasks per day of the week",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "since",
+								Usage: "only sections on or after `DATE`",
+							},
+							&cli.StringFlag{
+								Name:  "until",
+								Usage: "only sections on or before `DATE`",
+							},
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "count every entry instead of completed tasks",
+							},
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "print the report as JSON",
+							},
+							outputFlag,
+						},
+						Action: reportWeekday,
+					},
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "Show how many tasks are completed, with entry counts and weekly rates",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "per-tag",
+						Usage: "break the counts down by tag",
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "only sections on or after `DATE`",
+					},
+					&cli.StringFlag{
+						Name:  "until",
+						Usage: "only sections on or before `DATE`",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the stats as JSON",
+					},
+					outputFlag,
+				},
+				Action: showStats,
+			},
+			{
+				Name:   "status",
+				Usage:  "Show today's progress",
+				Flags:  []cli.Flag{outputFlag},
+				Action: showStatus,
+			},
+			{
+				Name:      "open",
+				Usage:     "Open the file attached to a note",
+				ArgsUsage: "[number]",
+				Action:    openAttachment,
+			},
+			{
+				Name:   "week",
+				Usage:  "Show the sections of this week",
+				Flags:  viewFlags,
+				Action: showWeek,
+			},
+			{
+				Name:      "recent",
+				Usage:     "Show the sections of the last N days, 7 by default",
+				ArgsUsage: "[N]",
+				Flags:     viewFlags,
+				Action:    showRecent,
+			},
+			{
+				Name:  "checksum",
+				Usage: "Print the SHA-256 of the log",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "raw",
+						Usage: "hash the exact bytes instead of the normalized content",
+					},
+					outputFlag,
+				},
+				Action: checksum,
+			},
+			{
+				Name:      "edit",
+				Usage:     "Open the log, or a single entry, in $EDITOR",
+				ArgsUsage: "[task number | id:N] [new text]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "date",
+						Aliases: []string{"d"},
+						Usage:   "jump to the section for `DATE`",
+					},
+					reverseFlag,
+					dryRunFlag,
+				},
+				Action: editLog,
+			},
+			{
+				Name:   "capture",
+				Usage:  "Capture a note into the inbox",
+				Flags:  []cli.Flag{dryRunFlag},
+				Action: capture,
+			},
+			{
+				Name:   "inbox",
+				Usage:  "List the inbox",
+				Flags:  []cli.Flag{outputFlag},
+				Action: listInbox,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "promote",
+						Usage:     "Move an inbox item into a day",
+						ArgsUsage: "NUMBER",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "date",
+								Aliases: []string{"d"},
+								Usage:   "move it to `DATE` instead of today",
+							},
+							&cli.BoolFlag{
+								Name:  "task",
+								Usage: "add it as a task instead of a note",
+							},
+							dryRunFlag,
+						},
+						Action: promoteInbox,
+					},
+				},
+			},
+			{
+				Name:   "ui",
+				Usage:  "Browse and change the log in a terminal UI",
+				Action: runUI,
+			},
+			{
+				Name:  "today",
+				Usage: "Show today's section with its progress",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "quiet",
+						Aliases: []string{"q"},
+						Usage:   "leave out the progress bar",
+					},
+				},
+				Action: showToday,
+			},
+			{
+				Name:      "show",
+				Usage:     "Show a day's section or the whole log",
+				ArgsUsage: "[date]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "show the whole log, followed by its archives",
+					},
+					&cli.StringFlag{
+						Name:    "date",
+						Aliases: []string{"d"},
+						Usage:   "show the section for `DATE`",
+					},
+					&cli.BoolFlag{
+						Name:    "yesterday",
+						Aliases: []string{"y"},
+						Usage:   "show yesterday's section",
+					},
+					&cli.BoolFlag{
+						Name:  "hide-done",
+						Usage: "leave out completed tasks",
+					},
+					&cli.BoolFlag{
+						Name:  "hide-cancelled",
+						Usage: "leave out cancelled tasks",
+					},
+					&cli.BoolFlag{
+						Name:  "hide-empty",
+						Usage: "leave out sections without entries",
+					},
+					orderFlag,
+					outputFlag,
+				},
+				Action: showLog,
+			},
+			{
+				Name:      "flush",
+				Usage:     "Add each line of a scratch file as a note",
+				ArgsUsage: "FILE",
+				Flags: []cli.Flag{
+					dryRunFlag,
+					&cli.StringFlag{
+						Name:    "date",
+						Aliases: []string{"d"},
+						Usage:   "file the notes under `DATE` instead of today",
+					},
+				},
+				Action: flush,
+			},
+			{
+				Name:      "cancel",
+				Usage:     "Cancel a task, or every open task of a day",
+				ArgsUsage: "[task number | id:N]",
+				Action:    cancelTask,
+				Flags: []cli.Flag{
+					dryRunFlag,
+					reverseFlag,
+					&cli.BoolFlag{
+						Name:  "all-open",
+						Usage: "cancel every open task in the section for --date",
+					},
+					&cli.StringFlag{
+						Name:    "date",
+						Aliases: []string{"d"},
+						Usage:   "cancel tasks in the section for `DATE`",
+					},
+				},
+			},
+			{
+				Name:      "reopen",
+				Usage:     "Mark a completed task as open again",
+				ArgsUsage: "<id:N>",
+				Flags:     []cli.Flag{dryRunFlag},
+				Action:    reopenTask,
+			},
+			{
+				Name:      "delete",
+				Usage:     "Remove an entry from the log",
+				ArgsUsage: "<task number | id:N>",
+				Flags:     []cli.Flag{reverseFlag, dryRunFlag},
+				Action:    deleteEntry,
+			},
+			{
+				Name:      "migrate",
+				Usage:     "Move an open task to today, marking it migrated",
+				ArgsUsage: "[task number | id:N]",
+				Action:    migrateTask,
+				Flags: []cli.Flag{
+					dryRunFlag,
+					reverseFlag,
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "migrate every open task from previous days, and future log tasks whose month has come",
+					},
+				},
+			},
+			{
+				Name:   "archive",
+				Usage:  "Move the sections of past months out of the log into a file per month",
+				Action: archiveLog,
+				Flags: []cli.Flag{
+					dryRunFlag,
+					&cli.StringFlag{
+						Name:  "before",
+						Usage: "archive the sections before `YYYY-MM` instead of the current month",
+					},
+				},
+			},
+			{
+				Name:   "review",
+				Usage:  "Review the day's completed tasks and notes, then decide on each open task",
+				Action: reviewLog,
+				Flags: []cli.Flag{
+					dryRunFlag,
+					&cli.BoolFlag{
+						Name:  "week",
+						Usage: "review the seven days up to today",
+					},
+				},
+			},
+			{
+				Name:      "start",
+				Usage:     "Mark a task as in progress",
+				ArgsUsage: "<task number | id:N>",
+				Flags:     []cli.Flag{reverseFlag, dryRunFlag},
+				Action:    startTask,
+			},
+			{
+				Name:   "backlog",
+				Usage:  "List the backlog",
+				Flags:  []cli.Flag{outputFlag},
+				Action: listBacklog,
+				Subcommands: []*cli.Command{
+					{
+						Name:   "push",
+						Usage:  "Move an open task into the backlog",
+						Flags:  []cli.Flag{reverseFlag, dryRunFlag},
+						Action: pushBacklog,
+					},
+					{
+						Name:   "pull",
+						Usage:  "Move a backlog task into today",
+						Flags:  []cli.Flag{dryRunFlag},
+						Action: pullBacklog,
+					},
+				},
 			},
 		},
 	}
-	app.Run(os.Args)
+
+	err := cfgErr
+	if err == nil {
+		var aliases map[string][]string
+		aliases, err = getAliases()
+		if err == nil {
+			err = registerAliases(app, aliases)
+		}
+	}
+	if err == nil {
+		err = app.Run(withDefaults(app, os.Args, cfg.Defaults))
+	}
+	if err != nil {
+		cli.HandleExitCoder(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
 }
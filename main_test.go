@@ -0,0 +1,772 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs blt itself when BLT_TEST_MAIN is set, so that tests can run
+// its commands in a process of their own.
+func TestMain(m *testing.M) {
+	if os.Getenv("BLT_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// setEnv sets the environment variable name to value for the rest of the
+// test.
+func setEnv(t *testing.T, name, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+// writeTestLog writes content to a log in a new directory, and makes it the
+// log blt uses on 20261015 for the rest of the test.
+func writeTestLog(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "blt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, ".BULLETLOG")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, "BULLETLOG_FILE", path)
+	setEnv(t, "BULLETLOG_DATE", "20261015")
+	return path
+}
+
+// readTestLog returns the content of the log at path.
+func readTestLog(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// bltError is how a run of blt failed.
+type bltError struct {
+	code   int
+	stderr string
+}
+
+func (e *bltError) Error() string {
+	return fmt.Sprintf("exit status %d: %s", e.code, strings.TrimSpace(e.stderr))
+}
+
+// runBlt runs blt with args, and returns what it printed to stdout.
+func runBlt(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "BLT_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		return stdout.String(), &bltError{exitErr.ExitCode(), stderr.String()}
+	}
+	return stdout.String(), nil
+}
+
+func TestOrderSections(t *testing.T) {
+	lines := []string{
+		"## 20261014",
+		"",
+		"- b",
+		"",
+		"## BACKLOG",
+		"",
+		"- later",
+		"",
+		"## 20261012",
+		"",
+		"* a",
+		"",
+		"## 20261015",
+		"",
+		"- c",
+	}
+
+	tests := []struct {
+		ascending bool
+		want      []string
+		indexes   []int
+	}{
+		{
+			ascending: true,
+			want: []string{
+				"## 20261012", "", "* a", "",
+				"## BACKLOG", "", "- later", "",
+				"## 20261014", "", "- b", "",
+				"## 20261015", "", "- c",
+			},
+			indexes: []int{8, 9, 10, 11, 4, 5, 6, 7, 0, 1, 2, 3, 12, 13, 14},
+		},
+		{
+			ascending: false,
+			want: []string{
+				"## 20261015", "", "- c", "",
+				"## BACKLOG", "", "- later", "",
+				"## 20261014", "", "- b", "",
+				"## 20261012", "", "* a", "",
+			},
+			indexes: []int{12, 13, 14, -1, 4, 5, 6, 7, 0, 1, 2, 3, 8, 9, 10, 11},
+		},
+	}
+	for _, tt := range tests {
+		got, indexes, err := orderSections(lines, tt.ascending)
+		if err != nil {
+			t.Fatalf("orderSections(ascending=%v): %v", tt.ascending, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("orderSections(ascending=%v) = %q, want %q", tt.ascending, got, tt.want)
+		}
+		if !reflect.DeepEqual(indexes, tt.indexes) {
+			t.Errorf("orderSections(ascending=%v) indexes = %v, want %v", tt.ascending, indexes, tt.indexes)
+		}
+	}
+}
+
+func TestViewOrder(t *testing.T) {
+	path := writeTestLog(t, "## 20261015\n\n- c\n\n## 20261012\n\n* a\n\n## 20261014\n\n- b\n\n## 20261001\n\n- old\n")
+	log := readTestLog(t, path)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"week"},
+			want: "## 20261015\n\n- c\n\n## 20261012\n\n* a\n\n## 20261014\n\n- b\n\n",
+		},
+		{
+			args: []string{"week", "--order", "asc"},
+			want: "## 20261012\n\n* a\n\n## 20261014\n\n- b\n\n## 20261015\n\n- c\n\n",
+		},
+		{
+			args: []string{"week", "--order", "desc"},
+			want: "## 20261015\n\n- c\n\n## 20261014\n\n- b\n\n## 20261012\n\n* a\n\n",
+		},
+		{
+			args: []string{"recent", "--order", "asc", "3"},
+			want: "## 20261014\n\n- b\n\n## 20261015\n\n- c\n\n",
+		},
+		{
+			args: []string{"recent", "--order", "desc", "30"},
+			want: "## 20261015\n\n- c\n\n## 20261014\n\n- b\n\n## 20261012\n\n* a\n\n## 20261001\n\n- old\n",
+		},
+		{
+			args: []string{"show", "--all", "--order", "asc"},
+			want: "## 20261001\n\n- old\n\n## 20261012\n\n* a\n\n## 20261014\n\n- b\n\n## 20261015\n\n- c\n\n",
+		},
+		{
+			args: []string{"show", "--all", "--order", "desc", "--hide-done"},
+			want: "## 20261015\n\n- c\n\n## 20261014\n\n- b\n\n## 20261012\n\n* a\n\n## 20261001\n\n- old\n",
+		},
+	}
+	for _, tt := range tests {
+		out, err := runBlt(t, tt.args...)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+		if out != tt.want {
+			t.Errorf("%q printed %q, want %q", tt.args, out, tt.want)
+		}
+	}
+	if got := readTestLog(t, path); got != log {
+		t.Errorf("views changed the log to %q", got)
+	}
+}
+
+func TestViewOrderJSON(t *testing.T) {
+	writeTestLog(t, "## 20261015\n\n- c\n\n## 20261012\n\n* a\n\n## 20261014\n\n- b\n")
+
+	for _, args := range [][]string{{"--json", "week", "--order", "asc"}, {"--json", "show", "--all", "--order", "asc"}} {
+		out, err := runBlt(t, args...)
+		if err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+		var entries []jsonEntry
+		if err := json.Unmarshal([]byte(out), &entries); err != nil {
+			t.Fatalf("%q printed %q: %v", args, out, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, fmt.Sprintf("%d %s %s", e.Line, e.Date, e.Text))
+		}
+		want := []string{"7 20261012 a", "11 20261014 b", "3 20261015 c"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q printed %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestCompleteReverse(t *testing.T) {
+	path := writeTestLog(t, "## 20261015\n\n- new a\n- new b\n\n## 20261014\n\n- old c\n")
+
+	out, err := runBlt(t, "tasks", "--reverse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0: old c\n1: new b\n2: new a\n"; out != want {
+		t.Errorf("tasks --reverse printed %q, want %q", out, want)
+	}
+
+	if _, err := runBlt(t, "complete", "--reverse", "1"); err != nil {
+		t.Fatal(err)
+	}
+	want := "## 20261015\n\n- new a\nx new b\n\n## 20261014\n\n- old c\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("complete --reverse 1 left %q, want %q", got, want)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	path := writeTestLog(t, "## 20261015\n\n- task\n* note\n")
+	out := filepath.Join(filepath.Dir(path), "out.txt")
+
+	for _, args := range [][]string{{"tasks"}, {"notes"}, {"week"}, {"export"}} {
+		want, err := runBlt(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(out, []byte(strings.Repeat("stale\n", 100)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		printed, err := runBlt(t, append(args, "-o", out)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed != "" {
+			t.Errorf("%q -o printed %q, want nothing", args, printed)
+		}
+		if got := readTestLog(t, out); got != want {
+			t.Errorf("%q -o wrote %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestCustomDoneMark(t *testing.T) {
+	path := writeTestLog(t, "## 20261015\n\n- open a\n✓ done b\n- open c\n")
+	setEnv(t, "BULLETLOG_DONE_MARKER", "✓")
+
+	out, err := runBlt(t, "tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0: open a\n1: open c\n"; out != want {
+		t.Errorf("tasks printed %q, want %q", out, want)
+	}
+
+	if _, err := runBlt(t, "complete", "1"); err != nil {
+		t.Fatal(err)
+	}
+	want := "## 20261015\n\n- open a\n✓ done b\n✓ open c\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("complete 1 left %q, want %q", got, want)
+	}
+
+	out, err = runBlt(t, "show", "--hide-done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "## 20261015\n\n- open a\n"; out != want {
+		t.Errorf("show --hide-done printed %q, want %q", out, want)
+	}
+
+	out, err = runBlt(t, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "20261015: 2 completed\n"; out != want {
+		t.Errorf("status printed %q, want %q", out, want)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	const log = "## 20261015\n\n- a\n"
+	path := writeTestLog(t, log)
+	scratch := filepath.Join(filepath.Dir(path), "scratch")
+	if err := ioutil.WriteFile(scratch, []byte("one\n# skipped\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runBlt(t, "flush", "--dry-run", scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out, "Would flush 2 notes\n") {
+		t.Errorf("flush --dry-run printed %q, want it to end with the notes it would flush", out)
+	}
+	if got := readTestLog(t, path); got != log {
+		t.Errorf("flush --dry-run changed the log to %q", got)
+	}
+	if got := readTestLog(t, scratch); got != "one\n# skipped\ntwo\n" {
+		t.Errorf("flush --dry-run left the scratch file %q, want it unchanged", got)
+	}
+
+	out, err = runBlt(t, "flush", "--date", "yesterday", scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Flushed 2 notes\n"; out != want {
+		t.Errorf("flush printed %q, want %q", out, want)
+	}
+	want := "## 20261015\n\n- a\n\n## 20261014\n\n* one\n\n* two\n\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("flush --date yesterday left %q, want %q", got, want)
+	}
+	if got := readTestLog(t, scratch); got != "" {
+		t.Errorf("flush left the scratch file %q, want it empty", got)
+	}
+}
+
+func TestAliasGlobalFlags(t *testing.T) {
+	path := writeTestLog(t, "## 20261015\n\n- home\n")
+	dir := filepath.Dir(path)
+	work := filepath.Join(dir, "work.BULLETLOG")
+	if err := ioutil.WriteFile(work, []byte("## 20261015\n\n- work\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(config, []byte(fmt.Sprintf("[books]\nwork = %q\n", work)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, "BULLETLOG_CONFIG", config)
+	setEnv(t, "BULLETLOG_ALIASES", "todo=tasks")
+
+	out, err := runBlt(t, "--book", "work", "--json", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []jsonEntry
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("--book work --json todo printed %q: %v", out, err)
+	}
+	if len(entries) != 1 || entries[0].Text != "work" {
+		t.Errorf("--book work --json todo printed %+v, want the task of the work notebook", entries)
+	}
+
+	out, err = runBlt(t, "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0: home\n"; out != want {
+		t.Errorf("todo printed %q, want %q", out, want)
+	}
+}
+
+func TestAddLeadingBlankLine(t *testing.T) {
+	tests := []struct {
+		log  string
+		want string
+	}{
+		{
+			log:  "\n## 20261014\n\n- old\n",
+			want: "\n## 20261015\n\n- new\n\n## 20261014\n\n- old\n",
+		},
+		{
+			log:  "\n## 20261015\n\n- old\n",
+			want: "\n## 20261015\n\n- old\n\n- new\n\n",
+		},
+		{
+			log:  "# comment\n\n## 20261014\n\n- old\n",
+			want: "# comment\n\n## 20261015\n\n- new\n\n## 20261014\n\n- old\n",
+		},
+	}
+	for _, tt := range tests {
+		path := writeTestLog(t, tt.log)
+		if _, err := runBlt(t, "task", "new"); err != nil {
+			t.Fatalf("task new on %q: %v", tt.log, err)
+		}
+		if got := readTestLog(t, path); got != tt.want {
+			t.Errorf("task new on %q left %q, want %q", tt.log, got, tt.want)
+		}
+	}
+}
+
+func TestEntrySpacing(t *testing.T) {
+	tests := []struct {
+		spacing string
+		want    string
+	}{
+		{spacing: "0", want: "## 20261015\n\n- a\n* b\n\n## 20261014\n\n- old\n"},
+		{spacing: "1", want: "## 20261015\n\n- a\n\n* b\n\n## 20261014\n\n- old\n"},
+	}
+	for _, tt := range tests {
+		path := writeTestLog(t, "## 20261014\n\n- old\n")
+		setEnv(t, "BULLETLOG_ENTRY_SPACING", tt.spacing)
+		if _, err := runBlt(t, "task", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runBlt(t, "add", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestLog(t, path); got != tt.want {
+			t.Errorf("entry_spacing %s wrote %q, want %q", tt.spacing, got, tt.want)
+		}
+
+		// Either spacing reads the log the other wrote.
+		for _, spacing := range []string{"0", "1"} {
+			setEnv(t, "BULLETLOG_ENTRY_SPACING", spacing)
+			out, err := runBlt(t, "tasks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "0: a\n1: old\n"; out != want {
+				t.Errorf("tasks with entry_spacing %s on a log written with %s printed %q, want %q", spacing, tt.spacing, out, want)
+			}
+			out, err = runBlt(t, "notes")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "* b\n"; out != want {
+				t.Errorf("notes with entry_spacing %s on a log written with %s printed %q, want %q", spacing, tt.spacing, out, want)
+			}
+		}
+	}
+}
+
+func TestCompleteLine(t *testing.T) {
+	const log = "## 20261015\n\n- a\n* note\n- b\n"
+	path := writeTestLog(t, log)
+
+	for _, line := range []string{"4", "2", "99"} {
+		if _, err := runBlt(t, "complete", "--line", line); err == nil {
+			t.Errorf("complete --line %s succeeded, want an error", line)
+		}
+		if got := readTestLog(t, path); got != log {
+			t.Fatalf("complete --line %s left %q, want the log unchanged", line, got)
+		}
+	}
+	if _, err := runBlt(t, "complete", "--line", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runBlt(t, "complete", "--line", "3"); err != nil {
+		t.Fatal(err)
+	}
+	want := "## 20261015\n\nx a\n* note\nx b\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("complete --line left %q, want %q", got, want)
+	}
+
+	// Lines count continuation lines, as the file does.
+	path = writeTestLog(t, "## 20261015\n\n- a\n  more\n- b\n")
+	if _, err := runBlt(t, "complete", "--line", "4"); err == nil {
+		t.Error("complete --line 4 succeeded on a continuation line, want an error")
+	}
+	if _, err := runBlt(t, "complete", "--line", "5"); err != nil {
+		t.Fatal(err)
+	}
+	want = "## 20261015\n\n- a\n  more\nx b\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("complete --line 5 left %q, want %q", got, want)
+	}
+}
+
+const listLog = "## 20261015\n\n### 09:00\n\n- a\n* note\n\n### 10:00\n\n/ b\nx done\n\n## 20261012\n\n- old #work\n* plain #work\n* ! urgent #work\n"
+
+func TestWriteTasks(t *testing.T) {
+	today := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		opts listOptions
+		want string
+	}{
+		{
+			want: "0: a\n1: / b\n2: old #work\n",
+		},
+		{
+			opts: listOptions{reverse: true},
+			want: "0: old #work\n1: / b\n2: a\n",
+		},
+		{
+			opts: listOptions{times: true},
+			want: "0: 09:00 a\n1: 10:00 / b\n2: old #work\n",
+		},
+		{
+			opts: listOptions{age: true, today: today},
+			want: "0: a (0d)\n1: / b (0d)\n2: old #work (3d)\n",
+		},
+		{
+			opts: listOptions{sortBy: "age"},
+			want: "2: old #work\n0: a\n1: / b\n",
+		},
+		{
+			opts: listOptions{tag: "work"},
+			want: "2: old #work\n",
+		},
+		{
+			opts: listOptions{status: "in-progress"},
+			want: "1: / b\n",
+		},
+		{
+			opts: listOptions{status: "open"},
+			want: "0: a\n2: old #work\n",
+		},
+		{
+			opts: listOptions{summary: true},
+			want: "0: a\n1: / b\n2: old #work\n(3 open, 1 done, 0 cancelled, 0 migrated)\n",
+		},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := writeTasks(&b, strings.NewReader(listLog), tt.opts); err != nil {
+			t.Fatalf("writeTasks(%+v): %v", tt.opts, err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("writeTasks(%+v) wrote %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestWriteTasksJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeTasks(&b, strings.NewReader(listLog), listOptions{json: true}); err != nil {
+		t.Fatal(err)
+	}
+	var entries []jsonEntry
+	if err := json.Unmarshal(b.Bytes(), &entries); err != nil {
+		t.Fatalf("writeTasks wrote %q: %v", b.String(), err)
+	}
+	var got []string
+	for _, e := range entries {
+		if e.Number == nil {
+			t.Fatalf("writeTasks wrote %+v without a number", e)
+		}
+		got = append(got, fmt.Sprintf("%d:%d %s %s %s", *e.Number, e.Line, e.Date, e.Status, e.Text))
+	}
+	want := []string{"0:5 20261015 open a", "1:10 20261015 in-progress b", "2:15 20261012 open old #work"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("writeTasks wrote %q, want %q", got, want)
+	}
+}
+
+func TestWriteBullets(t *testing.T) {
+	tests := []struct {
+		opts listOptions
+		want string
+	}{
+		{
+			want: "* note\n* plain #work\n* ! urgent #work\n",
+		},
+		{
+			opts: listOptions{times: true},
+			want: "09:00 * note\n* plain #work\n* ! urgent #work\n",
+		},
+		{
+			opts: listOptions{priority: true},
+			want: "* ! urgent #work\n",
+		},
+		{
+			opts: listOptions{tag: "work"},
+			want: "* plain #work\n* ! urgent #work\n",
+		},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := writeBullets(&b, strings.NewReader(listLog), markers["note"], tt.opts); err != nil {
+			t.Fatalf("writeBullets(%+v): %v", tt.opts, err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("writeBullets(%+v) wrote %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestAddAscending(t *testing.T) {
+	const log = "## 20261012\n\n- a\n\n## 20261014\n\n- b\n"
+	tests := []struct {
+		date string
+		want string
+	}{
+		{
+			date: "20261010",
+			want: "## 20261010\n\n- new\n\n## 20261012\n\n- a\n\n## 20261014\n\n- b\n",
+		},
+		{
+			date: "20261013",
+			want: "## 20261012\n\n- a\n\n## 20261013\n\n- new\n\n## 20261014\n\n- b\n",
+		},
+		{
+			date: "20261016",
+			want: "## 20261012\n\n- a\n\n## 20261014\n\n- b\n\n## 20261016\n\n- new\n\n",
+		},
+		{
+			date: "20261014",
+			want: "## 20261012\n\n- a\n\n## 20261014\n\n- b\n\n- new\n\n",
+		},
+	}
+	for _, tt := range tests {
+		path := writeTestLog(t, log)
+		setEnv(t, "BULLETLOG_SECTION_ORDER", "asc")
+		setEnv(t, "BULLETLOG_DATE", tt.date)
+		if _, err := runBlt(t, "task", "new"); err != nil {
+			t.Fatalf("task new on %s: %v", tt.date, err)
+		}
+		if got := readTestLog(t, path); got != tt.want {
+			t.Errorf("task new on %s left %q, want %q", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestSingleTask(t *testing.T) {
+	for _, log := range []string{"## 20261015\n\n- only\n", "## 20261015\n\n- only"} {
+		path := writeTestLog(t, log)
+
+		out, err := runBlt(t, "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "0: only\n"; out != want {
+			t.Errorf("tasks on %q printed %q, want %q", log, out, want)
+		}
+
+		if _, err := runBlt(t, "complete", "1"); err == nil {
+			t.Errorf("complete 1 on %q succeeded, want an error", log)
+		}
+		if got := readTestLog(t, path); got != log {
+			t.Errorf("complete 1 on %q left %q, want the log unchanged", log, got)
+		}
+
+		if _, err := runBlt(t, "complete", "0"); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := readTestLog(t, path), "## 20261015\n\nx only\n"; got != want {
+			t.Errorf("complete 0 on %q left %q, want %q", log, got, want)
+		}
+	}
+}
+
+// chdir changes the working directory to dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestWorkingDir(t *testing.T) {
+	path := writeTestLog(t, "## 20261015\n\n- a\n")
+	setEnv(t, "BULLETLOG_FILE", ".BULLETLOG")
+
+	elsewhere, err := ioutil.TempDir("", "blt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(elsewhere) })
+	chdir(t, elsewhere)
+
+	if _, err := runBlt(t, "-C", filepath.Dir(path), "task", "b"); err != nil {
+		t.Fatal(err)
+	}
+	want := "## 20261015\n\n- a\n\n- b\n\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("-C task b left %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, ".BULLETLOG")); !os.IsNotExist(err) {
+		t.Errorf("-C task b touched .BULLETLOG in the directory blt was started in")
+	}
+}
+
+// exitCode returns the status a run of blt exited with.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*bltError); ok {
+		return e.code
+	}
+	return -1
+}
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		log  string
+		args []string
+		want int
+	}{
+		{log: "## 20261015\n\n- a\n", args: []string{"tasks"}, want: 0},
+		{log: "## 20261015\n\n- a\n", args: []string{"complete", "3"}, want: exitUsage},
+		{log: "## 20261015\n\n- a\n", args: []string{"tasks", "--sort", "bogus"}, want: exitUsage},
+		{log: "## 20261015\n\n- a\n", args: []string{"week", "--order", "bogus"}, want: exitUsage},
+		{log: "## 20261015\n\n- a\n", args: []string{"search", "a"}, want: 0},
+		{log: "## 20261015\n\n- a\n", args: []string{"search", "zzz"}, want: exitNoMatch},
+		{log: "## 20261015\n\n- a\n", args: []string{"search", "--regex", "("}, want: exitUsage},
+		{log: "## 20261315\n\n- a\n", args: []string{"show"}, want: exitParse},
+		{log: "## 20261315\n\n- a\n", args: []string{"task", "b"}, want: exitParse},
+	}
+	for _, tt := range tests {
+		writeTestLog(t, tt.log)
+		_, err := runBlt(t, tt.args...)
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("%q on %q exited with %d (%v), want %d", tt.args, tt.log, got, err, tt.want)
+		}
+	}
+
+	path := writeTestLog(t, "")
+	setEnv(t, "BULLETLOG_FILE", filepath.Join(filepath.Dir(path), "missing", ".BULLETLOG"))
+	_, err := runBlt(t, "tasks")
+	if got := exitCode(err); got != exitIO {
+		t.Errorf("tasks on a log in a missing directory exited with %d (%v), want %d", got, err, exitIO)
+	}
+}
+
+func TestAddFlagLikeText(t *testing.T) {
+	path := writeTestLog(t, "")
+
+	for _, args := range [][]string{
+		{"task", "--", "fix", "the", "-d", "option"},
+		{"task", "fix", "the", "--due", "flag"},
+		{"task", "--", "-d", "is", "a", "flag"},
+	} {
+		if _, err := runBlt(t, args...); err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+	}
+	want := "## 20261015\n\n- fix the -d option\n\n- fix the --due flag\n\n- -d is a flag\n\n"
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("task left %q, want %q", got, want)
+	}
+
+	// A flag after `-` was meant as one, not as text read from stdin.
+	if _, err := runBlt(t, "task", "-", "-d", "tomorrow"); exitCode(err) != exitUsage {
+		t.Errorf("task - -d tomorrow: %v, want a usage error", err)
+	}
+	if got := readTestLog(t, path); got != want {
+		t.Errorf("task - -d tomorrow changed the log to %q", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// monthlyStorage keeps a log as a plain-text file like textStorage, except
+// that once a month is over, its date sections move into a file of their own
+// next to the log, such as .BULLETLOG.2024-06, so that the file every command
+// rewrites holds only the current month, what is ahead of it and the undated
+// sections. The months split off are listed in an index, .BULLETLOG.months,
+// and a month's file is rewritten only when a change reaches into it.
+//
+// Loading puts the months back where they belong by date, so commands see
+// the log whole. A log without an index loads as a plain-text one, which is
+// how a log kept as text starts to be split.
+type monthlyStorage struct{}
+
+func monthsPath(path string) string { return path + ".months" }
+
+func monthPath(path string, month string) string { return path + "." + month }
+
+// monthOf returns the month the file split off from the log at path keeps.
+func monthOf(path string, file string) (string, bool) {
+	month := strings.TrimPrefix(file, path+".")
+	if month == file {
+		return "", false
+	}
+	_, err := time.Parse(archiveMonthFormat, month)
+	return month, err == nil
+}
+
+// readMonths returns the months listed in the index of the log at path,
+// oldest first.
+func readMonths(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(monthsPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ioError(err)
+	}
+	var months []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if month := strings.TrimSpace(line); month != "" {
+			months = append(months, month)
+		}
+	}
+	sort.Strings(months)
+	return months, nil
+}
+
+func (monthlyStorage) Load(path string) ([]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	months, err := readMonths(path)
+	if err != nil || len(months) == 0 {
+		return lines, err
+	}
+	if !sectionsAscending() {
+		sort.Sort(sort.Reverse(sort.StringSlice(months)))
+	}
+
+	var past []string
+	for _, month := range months {
+		monthLines, err := readLines(monthPath(path, month))
+		if err != nil {
+			return nil, err
+		}
+		past = append(past, monthLines...)
+	}
+	sections, err := newLog(past).Sections()
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(sections) == 0 || sections[0].Date == nil {
+		return lines, nil
+	}
+	// The past months keep their order, so they go where the first of their
+	// sections belongs among those of the current month.
+	i, _, err := newLog(lines).FindSection(*sections[0].Date)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	all := make([]string, 0, len(lines)+len(past))
+	all = append(all, lines[:i]...)
+	all = append(all, past...)
+	return append(all, lines[i:]...), nil
+}
+
+// Save splits off the sections of the months before the current one. The
+// months are written before the index and the index before the log, so that
+// a failure part way leaves sections in two files rather than in none.
+func (monthlyStorage) Save(path string, lines []string) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	current := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	kept, byMonth, err := splitMonths(lines, func(date time.Time) bool { return date.Before(current) })
+	if err != nil {
+		return err
+	}
+	old, err := readMonths(path)
+	if err != nil {
+		return err
+	}
+
+	months := make([]string, 0, len(byMonth))
+	for month := range byMonth {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months {
+		file := monthPath(path, month)
+		if saved, err := readArchive(file); err == nil && strings.Join(saved, "\n") == strings.Join(byMonth[month], "\n") {
+			continue
+		}
+		if err := writeLines(file, byMonth[month]); err != nil {
+			return err
+		}
+	}
+	if strings.Join(months, "\n") != strings.Join(old, "\n") {
+		if err := writeLines(monthsPath(path), months); err != nil {
+			return err
+		}
+	}
+	if err := writeLines(path, kept); err != nil {
+		return err
+	}
+	for _, month := range old {
+		if _, ok := byMonth[month]; !ok {
+			if err := os.Remove(monthPath(path, month)); err != nil && !os.IsNotExist(err) {
+				return ioError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Files returns the log file, then the index and the months' files once the
+// log has been split. The index stays when no month is left, to tell that the
+// log was split.
+func (monthlyStorage) Files(path string) ([]string, error) {
+	if _, err := os.Stat(monthsPath(path)); os.IsNotExist(err) {
+		return []string{path}, nil
+	}
+	months, err := readMonths(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path, monthsPath(path)}
+	for _, month := range months {
+		files = append(files, monthPath(path, month))
+	}
+	return files, nil
+}
@@ -0,0 +1,653 @@
+// Package bulletlog reads and rewrites bullet logs: plain text files made of
+// `## YYYYMMDD` sections, each holding one entry per line such as `- a task`
+// or `* a note`.
+//
+// A Log keeps every line as it was read, so rewriting a log only changes the
+// lines an operation touches. An entry with continuation lines is kept as a
+// single line holding them after newlines, so that it is handled as a whole.
+//
+// A log is read line by line with this grammar, where a line ends at LF, CRLF
+// or the end of the file:
+//
+//	log          = { line }
+//	line         = header | sub-header | entry | continuation | blank | other
+//	header       = "## " text
+//	sub-header   = "### " HH:MM
+//	entry        = marker " " text       ; marker is not "" and not "#..."
+//	continuation = indent text           ; right after an entry or continuation
+//	blank        = { " " | "\t" }
+//	indent       = " " | "\t"
+//
+// A byte order mark at the start of the log is dropped. Any other line, such
+// as a `# Title` or an HTML comment, is kept as it is but means nothing to
+// the log.
+package bulletlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DateFormat is the layout of the date in a section header.
+const DateFormat = "20060102"
+
+// TimeFormat is the layout of the time in a `### HH:MM` sub-header.
+const TimeFormat = "15:04"
+
+// BacklogHeader heads the undated section holding deferred tasks. It is kept
+// at the bottom of the log, below every date section.
+const BacklogHeader = "## BACKLOG"
+
+// InboxHeader heads the undated section collecting captured items until they
+// are triaged into a day. It is kept at the top of the log.
+const InboxHeader = "## INBOX"
+
+// FutureHeader heads the undated future log, holding tasks set aside for a
+// later month. Like the backlog it is kept below every date section.
+const FutureHeader = "## FUTURE"
+
+// RecurringHeader heads the undated section holding the rules of recurring
+// tasks. Like the backlog it is kept below every date section.
+const RecurringHeader = "## RECURRING"
+
+// CollectionPrefix starts the header of a named collection, such as
+// `## COLLECTION: Books to read`. Collections are kept below the date
+// sections.
+const CollectionPrefix = "## COLLECTION: "
+
+// IsCollection reports whether line is the header of a collection.
+func IsCollection(line string) bool {
+	return strings.HasPrefix(line, CollectionPrefix) && CollectionName(line) != ""
+}
+
+// CollectionName returns the name in a collection header.
+func CollectionName(line string) string {
+	return strings.TrimSpace(strings.TrimPrefix(line, CollectionPrefix))
+}
+
+// IsUndated reports whether line is the header of one of the undated
+// sections, including collections.
+func IsUndated(line string) bool {
+	switch line {
+	case InboxHeader, BacklogHeader, FutureHeader, RecurringHeader:
+		return true
+	}
+	return IsCollection(line)
+}
+
+// IsDeferred reports whether the tasks under the header line are kept out of
+// the daily tasks.
+func IsDeferred(line string) bool {
+	return IsUndated(line) && line != InboxHeader
+}
+
+// IsHeader reports whether line starts a section.
+func IsHeader(line string) bool {
+	return strings.HasPrefix(line, "## ")
+}
+
+// IsPreamble reports whether a line may precede the first section: blank
+// lines, comments and other headings such as a `# Title`.
+func IsPreamble(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "<!--") || (strings.HasPrefix(line, "#") && !IsHeader(line))
+}
+
+// ParseHeader returns the date of a `## YYYYMMDD` header.
+func ParseHeader(line string) (*time.Time, error) {
+	if !strings.HasPrefix(line, "##") {
+		return nil, errors.New("The prefix must be ##")
+	}
+	f := strings.Fields(line)
+	if len(f) != 2 || f[0] != "##" {
+		return nil, errors.New("Invalid header notion")
+	}
+	t, err := time.Parse(DateFormat, f[1])
+	t = t.Truncate(24 * time.Hour)
+	return &t, err
+}
+
+// ParseSubHeader returns the time of a `### HH:MM` sub-header. Sub-headers
+// only subdivide a date section; everything up to the next `## ` header still
+// belongs to the same day.
+func ParseSubHeader(line string) (string, error) {
+	if !strings.HasPrefix(line, "###") {
+		return "", errors.New("The prefix must be ###")
+	}
+	f := strings.Fields(line)
+	if len(f) != 2 || f[0] != "###" {
+		return "", errors.New("Invalid sub-header notion")
+	}
+	t, err := time.Parse(TimeFormat, f[1])
+	if err != nil {
+		return "", err
+	}
+	return t.Format(TimeFormat), nil
+}
+
+// SubHeader returns the lines opening the sub-section for slot, or nothing
+// when slot is "".
+func SubHeader(slot string) []string {
+	if slot == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("### %s", slot), ""}
+}
+
+// Entry is a single bullet: a marker followed by its text, which holds its
+// continuation lines after newlines.
+type Entry struct {
+	// Line is the index of the entry in Log.Lines.
+	Line   int
+	Marker string
+	Text   string
+}
+
+// DefaultMarkers returns the markers bullets start with by default: the
+// markers of tasks, notes, events and habits, and those of the states of
+// tasks.
+func DefaultMarkers() map[string]bool {
+	return map[string]bool{
+		"-": true, "*": true, "o": true, "+": true,
+		"x": true, "/": true, ">": true, "~": true,
+	}
+}
+
+// Parser reads logs. The zero Parser takes the DefaultMarkers.
+type Parser struct {
+	// Markers are those bullets start with. Programs marking tasks
+	// otherwise give DefaultMarkers with theirs added.
+	Markers map[string]bool
+}
+
+var defaultMarkers = DefaultMarkers()
+
+func (p Parser) isMarker(m string) bool {
+	if p.Markers == nil {
+		return defaultMarkers[m]
+	}
+	return p.Markers[m]
+}
+
+// ParseEntry splits a bullet line into its marker and text. It reports false
+// for lines that are not entries, such as headers, blank lines, indented
+// lines and lines led by anything but one of the markers of p.
+func (p Parser) ParseEntry(line string) (Entry, bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Entry{}, false
+	}
+	f := strings.SplitN(line, " ", 2)
+	if len(f) != 2 || !p.isMarker(f[0]) || isIndented(line) {
+		return Entry{}, false
+	}
+	return Entry{Marker: f[0], Text: f[1]}, true
+}
+
+// ParseEntry is Parser.ParseEntry with the DefaultMarkers.
+func ParseEntry(line string) (Entry, bool) {
+	return Parser{}.ParseEntry(line)
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %s", e.Marker, e.Text)
+}
+
+// Section is a `## ` header with the lines up to the next one.
+type Section struct {
+	Header string
+	// Date is nil for the undated sections: INBOX, BACKLOG, FUTURE,
+	// RECURRING and collections.
+	Date *time.Time
+	// Start is the index of the header in Log.Lines, and End the index just
+	// past the last line of the section.
+	Start, End int
+	Entries    []Entry
+}
+
+// Log is a bullet log held as its lines.
+type Log struct {
+	Lines []string
+	// Parser tells the entries of Lines apart.
+	Parser Parser
+
+	// Ascending keeps the newest section at the bottom rather than the top.
+	Ascending bool
+	// Spacing is the number of blank lines written between entries.
+	Spacing int
+	// OpenMarkers are the markers of outstanding tasks.
+	OpenMarkers []string
+	// DoneMark is the marker Complete writes.
+	DoneMark string
+	// DayTemplate, if set, returns the lines Append starts a new section for
+	// date with.
+	DayTemplate func(date time.Time) ([]string, error)
+}
+
+// New returns a Log of lines with the default settings.
+func New(lines []string) *Log {
+	return &Log{
+		Lines:       lines,
+		Spacing:     1,
+		OpenMarkers: []string{"-", "/"},
+		DoneMark:    "x",
+	}
+}
+
+// Kind is what a line is in the grammar of a log.
+type Kind int
+
+// The kinds of lines.
+const (
+	OtherLine Kind = iota
+	BlankLine
+	HeaderLine
+	SubHeaderLine
+	EntryLine
+	ContinuationLine
+)
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// Classify returns the kind of line, following a line of kind prev.
+func (p Parser) Classify(line string, prev Kind) Kind {
+	switch {
+	case strings.TrimSpace(line) == "":
+		return BlankLine
+	case isIndented(line) && (prev == EntryLine || prev == ContinuationLine):
+		return ContinuationLine
+	case IsHeader(line):
+		return HeaderLine
+	}
+	if _, err := ParseSubHeader(line); err == nil {
+		return SubHeaderLine
+	}
+	if _, ok := p.ParseEntry(line); ok {
+		return EntryLine
+	}
+	return OtherLine
+}
+
+// Classify is Parser.Classify with the DefaultMarkers.
+func Classify(line string, prev Kind) Kind {
+	return Parser{}.Classify(line, prev)
+}
+
+// Line is a line of a log as a Scanner reads it.
+type Line struct {
+	// Index is the index of the line in Log.Lines, counting from 0, and
+	// Number the line of the file it starts on, counting from 1. They part
+	// after an entry with continuation lines.
+	Index  int
+	Number int
+	// Text is the line without its line ending. The text of an entry holds
+	// its continuation lines, each after a newline.
+	Text string
+	Kind Kind
+}
+
+// Scanner reads the lines of a log one at a time, an entry together with its
+// continuation lines.
+type Scanner struct {
+	parser Parser
+	reader *bufio.Reader
+	line   Line
+	err    error
+	done   bool
+	// started is set once the first line is read.
+	started bool
+	// next is a line read ahead of the entry before it, or nil.
+	next *string
+}
+
+// NewScanner returns a Scanner reading from r with p.
+func (p Parser) NewScanner(r io.Reader) *Scanner {
+	return &Scanner{parser: p, reader: bufio.NewReader(r), line: Line{Index: -1, Kind: BlankLine}}
+}
+
+// NewScanner is Parser.NewScanner with the DefaultMarkers.
+func NewScanner(r io.Reader) *Scanner {
+	return Parser{}.NewScanner(r)
+}
+
+// Scan reads the next line, which Line then returns. It returns false at the
+// end of the log or on an error, which Err then returns.
+func (s *Scanner) Scan() bool {
+	text, ok := s.read()
+	if !ok {
+		return false
+	}
+	kind := s.parser.Classify(text, s.line.Kind)
+	if kind == EntryLine {
+		for {
+			next, ok := s.read()
+			if !ok {
+				break
+			}
+			if s.parser.Classify(next, kind) != ContinuationLine {
+				s.next = &next
+				break
+			}
+			text += "\n" + next
+		}
+	}
+	number := s.line.Number + strings.Count(s.line.Text, "\n") + 1
+	s.line = Line{Index: s.line.Index + 1, Number: number, Text: text, Kind: kind}
+	return true
+}
+
+// read returns the next line of the file without its line ending.
+func (s *Scanner) read() (string, bool) {
+	if s.next != nil {
+		text := *s.next
+		s.next = nil
+		return text, true
+	}
+	if s.done {
+		return "", false
+	}
+	text, err := s.reader.ReadString('\n')
+	if err != nil {
+		s.done = true
+		if err != io.EOF {
+			s.err = err
+			return "", false
+		}
+		if text == "" {
+			return "", false
+		}
+	}
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	if !s.started {
+		text = strings.TrimPrefix(text, "\ufeff")
+	}
+	s.started = true
+	return text, true
+}
+
+// Line returns the line Scan read last.
+func (s *Scanner) Line() Line {
+	return s.line
+}
+
+// Err returns the error that stopped Scan, or nil at the end of the log.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// LineNumbers returns the line of the file each of lines starts on, counting
+// from 1, as Line.Number.
+func LineNumbers(lines []string) []int {
+	numbers := make([]int, len(lines))
+	n := 1
+	for i, line := range lines {
+		numbers[i] = n
+		n += strings.Count(line, "\n") + 1
+	}
+	return numbers
+}
+
+// LineNumber returns the line of the file lines[i] starts on, counting from
+// 1, or the line just past the end of the file for i == len(lines).
+func LineNumber(lines []string, i int) int {
+	n := i + 1
+	for _, line := range lines[:i] {
+		n += strings.Count(line, "\n")
+	}
+	return n
+}
+
+// LineIndex returns the index of the line of lines holding line n of the
+// file, counting from 1, and whether it starts on n rather than continues
+// there. It returns -1 for a line past the end of the file.
+func LineIndex(lines []string, n int) (int, bool) {
+	start := 1
+	for i, line := range lines {
+		end := start + strings.Count(line, "\n")
+		if n >= start && n <= end {
+			return i, n == start
+		}
+		start = end + 1
+	}
+	return -1, false
+}
+
+// Parse reads a log from r with p.
+func (p Parser) Parse(r io.Reader) (*Log, error) {
+	s := p.NewScanner(r)
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Line().Text)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	l := New(lines)
+	l.Parser = p
+	return l, nil
+}
+
+// Parse is Parser.Parse with the DefaultMarkers.
+func Parse(r io.Reader) (*Log, error) {
+	return Parser{}.Parse(r)
+}
+
+// Write writes the log to w.
+func (l *Log) Write(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	for _, line := range l.Lines {
+		fmt.Fprintln(writer, line)
+	}
+	return writer.Flush()
+}
+
+// Sections returns the sections of the log in file order. It fails on a
+// header that is neither a date nor one of the undated sections.
+func (l *Log) Sections() ([]Section, error) {
+	var sections []Section
+	for i, line := range l.Lines {
+		if IsHeader(line) {
+			section := Section{Header: line, Start: i, End: len(l.Lines)}
+			if !IsUndated(line) {
+				t, err := ParseHeader(line)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", LineNumber(l.Lines, i), err)
+				}
+				section.Date = t
+			}
+			if n := len(sections); n > 0 {
+				sections[n-1].End = i
+			}
+			sections = append(sections, section)
+			continue
+		}
+		if len(sections) == 0 {
+			continue
+		}
+		if e, ok := l.Parser.ParseEntry(line); ok {
+			e.Line = i
+			s := &sections[len(sections)-1]
+			s.Entries = append(s.Entries, e)
+		}
+	}
+	return sections, nil
+}
+
+// FindSection returns the index of the header for date. When there is no
+// such section, it returns the index at which one belongs chronologically,
+// below the inbox and above the other undated sections, and false.
+func (l *Log) FindSection(date time.Time) (int, bool, error) {
+	lines := l.Lines
+	start := 0
+	for start < len(lines) && IsPreamble(lines[start]) {
+		start++
+	}
+	if start < len(lines) && !IsHeader(lines[start]) {
+		_, err := ParseHeader(lines[start])
+		return 0, false, err
+	}
+
+	for i := start; i < len(lines); i++ {
+		if !IsHeader(lines[i]) {
+			continue
+		}
+		if lines[i] == InboxHeader {
+			continue
+		}
+		if IsDeferred(lines[i]) {
+			return i, false, nil
+		}
+		t, err := ParseHeader(lines[i])
+		if err != nil {
+			return 0, false, err
+		}
+		if date.Equal(*t) {
+			return i, true, nil
+		}
+		if l.Ascending && t.After(date) || !l.Ascending && date.After(*t) {
+			return i, false, nil
+		}
+	}
+	return len(lines), false, nil
+}
+
+// Append files entry at the end of the section for date, under the `###`
+// sub-header for slot unless slot is "". The section is created if needed,
+// starting with the lines of DayTemplate.
+func (l *Log) Append(date time.Time, slot string, entry string) error {
+	i, found, err := l.FindSection(date)
+	if err != nil {
+		return err
+	}
+	if !found {
+		var body []string
+		if l.DayTemplate != nil {
+			template, err := l.DayTemplate(date)
+			if err != nil {
+				return err
+			}
+			if len(template) > 0 {
+				body = append(template, "")
+			}
+		}
+		body = append(body, SubHeader(slot)...)
+		body = append(body, entry, "")
+		l.InsertSection(i, date, body)
+		return nil
+	}
+	l.AppendTo(i, slot, entry)
+	return nil
+}
+
+// InsertSection inserts a section for date with the lines of body at index i.
+func (l *Log) InsertSection(i int, date time.Time, body []string) {
+	lines := l.Lines
+	section := []string{"## " + date.Format(DateFormat), ""}
+	if i > 0 && lines[i-1] != "" {
+		section = append([]string{""}, section...)
+	}
+	section = append(section, body...)
+
+	result := make([]string, 0, len(lines)+len(section))
+	result = append(result, lines[:i]...)
+	result = append(result, section...)
+	l.Lines = append(result, lines[i:]...)
+}
+
+// AppendTo adds entry after the last line of the section whose header is at
+// i, under a new `### slot` sub-header unless the section already ends in
+// that slot.
+func (l *Log) AppendTo(i int, slot string, entry string) {
+	lines := l.Lines
+	lastSlot := ""
+	k := i + 1
+	for j := i + 1; j < len(lines) && !IsHeader(lines[j]); j++ {
+		if s, err := ParseSubHeader(lines[j]); err == nil {
+			lastSlot = s
+		}
+		if lines[j] != "" {
+			k = j + 1
+		}
+	}
+
+	var block []string
+	if k == i+1 {
+		block = append(block, "")
+	} else {
+		for n := 0; n < l.Spacing; n++ {
+			block = append(block, "")
+		}
+	}
+	if slot != "" && slot != lastSlot {
+		if k > i+1 && len(block) == 0 {
+			block = append(block, "")
+		}
+		block = append(block, SubHeader(slot)...)
+	}
+	block = append(block, entry)
+	if k == len(lines) || lines[k] != "" {
+		block = append(block, "")
+	}
+
+	result := make([]string, 0, len(lines)+len(block))
+	result = append(result, lines[:k]...)
+	result = append(result, block...)
+	l.Lines = append(result, lines[k:]...)
+}
+
+// IsOpen reports whether line is an outstanding task.
+func (l *Log) IsOpen(line string) bool {
+	for _, m := range l.OpenMarkers {
+		if strings.HasPrefix(line, m+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenTasks returns the indexes of the open tasks outside the undated
+// sections other than the inbox, in the order FindTask counts them.
+func (l *Log) OpenTasks() []int {
+	var tasks []int
+	deferred := false
+	for i, line := range l.Lines {
+		if IsHeader(line) {
+			deferred = IsDeferred(line)
+		}
+		if l.IsOpen(line) && !deferred {
+			tasks = append(tasks, i)
+		}
+	}
+	return tasks
+}
+
+// FindTask returns the index of the n-th task OpenTasks returns, counting
+// from 0. With reverse, tasks are counted from the bottom of the log up.
+func (l *Log) FindTask(n int, reverse bool) (int, error) {
+	tasks := l.OpenTasks()
+	if n < 0 || n >= len(tasks) {
+		return 0, fmt.Errorf("No such task: %d", n)
+	}
+	if reverse {
+		n = len(tasks) - 1 - n
+	}
+	return tasks[n], nil
+}
+
+// Complete marks the open task at index i as done.
+func (l *Log) Complete(i int) error {
+	if i < 0 || i >= len(l.Lines) {
+		return fmt.Errorf("Line %d is not an open task", i+1)
+	}
+	if !l.IsOpen(l.Lines[i]) {
+		return fmt.Errorf("Line %d is not an open task", LineNumber(l.Lines, i))
+	}
+	e, _ := l.Parser.ParseEntry(l.Lines[i])
+	l.Lines[i] = fmt.Sprintf("%s %s", l.DoneMark, e.Text)
+	return nil
+}
@@ -0,0 +1,227 @@
+package bulletlog
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(DateFormat, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseWrite(t *testing.T) {
+	tests := []struct {
+		in    string
+		lines []string
+		out   string
+	}{
+		{
+			in:    "## 20261015\n\n- a\n* b\n",
+			lines: []string{"## 20261015", "", "- a", "* b"},
+			out:   "## 20261015\n\n- a\n* b\n",
+		},
+		{
+			in:    "# Title\n\n## 20261015\n\n- a\n  more\n\tand more\n* b\n",
+			lines: []string{"# Title", "", "## 20261015", "", "- a\n  more\n\tand more", "* b"},
+			out:   "# Title\n\n## 20261015\n\n- a\n  more\n\tand more\n* b\n",
+		},
+		{
+			// An indented line after anything but an entry continues nothing.
+			in:    "## 20261015\n\n  stray\n- a\n",
+			lines: []string{"## 20261015", "", "  stray", "- a"},
+			out:   "## 20261015\n\n  stray\n- a\n",
+		},
+		{
+			in:    "\ufeff## 20261015\r\n\r\n- a\r\n  more\r\n",
+			lines: []string{"## 20261015", "", "- a\n  more"},
+			out:   "## 20261015\n\n- a\n  more\n",
+		},
+		{
+			in:    "## 20261015\n\n- a",
+			lines: []string{"## 20261015", "", "- a"},
+			out:   "## 20261015\n\n- a\n",
+		},
+		{
+			in:  "",
+			out: "",
+		},
+	}
+	for _, tt := range tests {
+		l, err := Parse(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(l.Lines, tt.lines) {
+			t.Errorf("Parse(%q) read %q, want %q", tt.in, l.Lines, tt.lines)
+		}
+		var b bytes.Buffer
+		if err := l.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != tt.out {
+			t.Errorf("Parse(%q) wrote back %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestScannerNumbers(t *testing.T) {
+	s := NewScanner(strings.NewReader("## 20261015\n\n- a\n  more\n* b\n"))
+	var got []Line
+	for s.Scan() {
+		got = append(got, s.Line())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Line{
+		{Index: 0, Number: 1, Text: "## 20261015", Kind: HeaderLine},
+		{Index: 1, Number: 2, Text: "", Kind: BlankLine},
+		{Index: 2, Number: 3, Text: "- a\n  more", Kind: EntryLine},
+		{Index: 3, Number: 5, Text: "* b", Kind: EntryLine},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %+v, want %+v", got, want)
+	}
+}
+
+func TestParserMarkers(t *testing.T) {
+	const in = "## 20261015\n\n✓ done\n  more\n"
+	if _, ok := ParseEntry("✓ done"); ok {
+		t.Error("ParseEntry took ✓ as a marker by default")
+	}
+
+	markers := DefaultMarkers()
+	markers["✓"] = true
+	p := Parser{Markers: markers}
+	l, err := p.Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"## 20261015", "", "✓ done\n  more"}
+	if !reflect.DeepEqual(l.Lines, want) {
+		t.Errorf("Parse read %q, want %q", l.Lines, want)
+	}
+	sections, err := l.Sections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 1 || len(sections[0].Entries) != 1 || sections[0].Entries[0].Marker != "✓" {
+		t.Errorf("Sections returned %+v, want one section with the ✓ entry", sections)
+	}
+
+	if DefaultMarkers()["✓"] {
+		t.Error("adding to the markers of a parser changed DefaultMarkers")
+	}
+}
+
+func TestFindSection(t *testing.T) {
+	lines := []string{
+		"# Title", "",
+		"## INBOX", "", "- triage", "",
+		"## 20261015", "", "- a", "",
+		"## 20261012", "", "- b", "",
+		"## BACKLOG", "", "- later",
+	}
+	tests := []struct {
+		date  string
+		i     int
+		found bool
+	}{
+		{date: "20261015", i: 6, found: true},
+		{date: "20261012", i: 10, found: true},
+		{date: "20261020", i: 6},
+		{date: "20261013", i: 10},
+		{date: "20261001", i: 14},
+	}
+	l := New(lines)
+	for _, tt := range tests {
+		i, found, err := l.FindSection(date(tt.date))
+		if err != nil {
+			t.Fatalf("FindSection(%s): %v", tt.date, err)
+		}
+		if i != tt.i || found != tt.found {
+			t.Errorf("FindSection(%s) = %d, %v, want %d, %v", tt.date, i, found, tt.i, tt.found)
+		}
+	}
+
+	l.Ascending = true
+	l.Lines = []string{"## 20261012", "", "- b", "", "## 20261015", "", "- a"}
+	if i, found, err := l.FindSection(date("20261013")); err != nil || i != 4 || found {
+		t.Errorf("ascending FindSection(20261013) = %d, %v, %v, want 4, false, nil", i, found, err)
+	}
+
+	l = New([]string{"## 2026-10-15", "", "- a"})
+	if _, _, err := l.FindSection(date("20261015")); err == nil {
+		t.Error("FindSection succeeded on a malformed header")
+	}
+}
+
+func TestInsertSection(t *testing.T) {
+	tests := []struct {
+		lines []string
+		i     int
+		want  []string
+	}{
+		{
+			lines: nil,
+			i:     0,
+			want:  []string{"## 20261015", "", "- new", ""},
+		},
+		{
+			lines: []string{"## 20261012", "", "- old", ""},
+			i:     0,
+			want:  []string{"## 20261015", "", "- new", "", "## 20261012", "", "- old", ""},
+		},
+		{
+			// A section after a line that is not blank is set apart.
+			lines: []string{"## 20261016", "", "- later"},
+			i:     3,
+			want:  []string{"## 20261016", "", "- later", "", "## 20261015", "", "- new", ""},
+		},
+	}
+	for _, tt := range tests {
+		l := New(tt.lines)
+		l.InsertSection(tt.i, date("20261015"), []string{"- new", ""})
+		if !reflect.DeepEqual(l.Lines, tt.want) {
+			t.Errorf("InsertSection(%d) on %q left %q, want %q", tt.i, tt.lines, l.Lines, tt.want)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	l := New([]string{"## 20261015", "", "- a\n  more", "* note", "/ b"})
+	for _, i := range []int{0, 3, -1, 5} {
+		if err := l.Complete(i); err == nil {
+			t.Errorf("Complete(%d) succeeded, want an error", i)
+		}
+	}
+	if err := l.Complete(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Complete(4); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"## 20261015", "", "x a\n  more", "* note", "x b"}
+	if !reflect.DeepEqual(l.Lines, want) {
+		t.Errorf("Complete left %q, want %q", l.Lines, want)
+	}
+	if err := l.Complete(2); err == nil {
+		t.Error("Complete succeeded on a done task")
+	}
+
+	l = New([]string{"## 20261015", "", "- a"})
+	l.DoneMark = "✓"
+	if err := l.Complete(2); err != nil {
+		t.Fatal(err)
+	}
+	if l.Lines[2] != "✓ a" {
+		t.Errorf("Complete with DoneMark ✓ wrote %q, want %q", l.Lines[2], "✓ a")
+	}
+}
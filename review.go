@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// reviewLog walks through the day, or with --week the seven days up to it:
+// it lists the tasks completed and the notes taken, then asks about each open
+// task from before today whether to migrate, complete or cancel it. The
+// answers are applied together at the end, as a single change.
+func reviewLog(c *cli.Context) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	// The log is not locked while waiting for answers; see applyReview.
+	releaseLock(path)
+	log, err := newStore(c, path, c.Command.FullName())
+	if err != nil {
+		return err
+	}
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+	lines, err := log.Load()
+	if err != nil {
+		return err
+	}
+
+	from := today
+	if c.Bool("week") {
+		from = today.AddDate(0, 0, -6)
+	}
+	if err := writeReviewSummary(os.Stdout, lines, from, today); err != nil {
+		return err
+	}
+
+	tasks := staleTasks(lines, today)
+	if len(tasks) == 0 {
+		fmt.Println("No open tasks from before today")
+		return nil
+	}
+	fmt.Printf("\nOpen tasks (%d): (m)igrate to today, (d)one, (c)ancel, (s)kip, (q)uit\n", len(tasks))
+	answers := map[int]byte{}
+	in := bufio.NewReader(os.Stdin)
+	for n, i := range tasks {
+		answer, err := askReview(in, fmt.Sprintf("[%d/%d] %s %s", n+1, len(tasks), reviewDate(lines, i), taskText(lines[i])))
+		if err != nil {
+			return err
+		}
+		if answer == 'q' {
+			break
+		}
+		if answer != 's' {
+			answers[i] = answer
+		}
+	}
+	return applyReview(log, path, lines, answers, today)
+}
+
+// writeReviewSummary writes the tasks completed and the notes taken in the
+// date sections from from to to.
+func writeReviewSummary(w io.Writer, lines []string, from time.Time, to time.Time) error {
+	sections, err := newLog(lines).Sections()
+	if err != nil {
+		return parseError(err)
+	}
+
+	var done, notes []string
+	for _, section := range sections {
+		if section.Date == nil || !inRange(*section.Date, &from, &to) {
+			continue
+		}
+		for _, e := range section.Entries {
+			line := lines[e.Line]
+			// Continuation lines line up under the text.
+			item := fmt.Sprintf("  %s %s", section.Date.Format(dateFormat), strings.ReplaceAll(unfoldText(taskText(line)), "\n", "\n"+strings.Repeat(" ", len(dateFormat)+3)))
+			switch {
+			case strings.HasPrefix(line, doneMark):
+				done = append(done, item)
+			case entryType(line) == "note":
+				notes = append(notes, item)
+			}
+		}
+	}
+
+	if from.Equal(to) {
+		fmt.Fprintf(w, "Review of %s\n", to.Format(dateFormat))
+	} else {
+		fmt.Fprintf(w, "Review of %s to %s\n", from.Format(dateFormat), to.Format(dateFormat))
+	}
+	fmt.Fprintf(w, "\nCompleted (%d)\n", len(done))
+	for _, item := range done {
+		fmt.Fprintln(w, item)
+	}
+	fmt.Fprintf(w, "\nNotes (%d)\n", len(notes))
+	for _, item := range notes {
+		fmt.Fprintln(w, item)
+	}
+	return nil
+}
+
+// reviewDate labels a task in the review with the date of its section, or
+// "future" for one from the future log.
+func reviewDate(lines []string, i int) string {
+	if t := sectionDate(lines, i); t != nil {
+		return t.Format(dateFormat)
+	}
+	return "future"
+}
+
+// askReview asks what to do with a task until given one of m, d, c, s or q.
+// The end of the input quits.
+func askReview(in *bufio.Reader, task string) (byte, error) {
+	for {
+		fmt.Printf("%s\n> ", task)
+		answer, err := in.ReadString('\n')
+		if err == io.EOF && answer == "" {
+			fmt.Println()
+			return 'q', nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if len(answer) == 1 && strings.Contains("mdcsq", answer) {
+			return answer[0], nil
+		}
+		fmt.Println("Answer m, d, c, s or q")
+	}
+}
+
+// applyReview carries out the answers to a review of lines, given by the
+// index of each task. The log is locked again for the change, which is given
+// up if the log changed meanwhile.
+func applyReview(log store, path string, lines []string, answers map[int]byte, today time.Time) error {
+	if len(answers) == 0 {
+		return nil
+	}
+	unlock, err := lockLog(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := log.Load()
+	if err != nil {
+		return err
+	}
+	if strings.Join(current, "\n") != strings.Join(lines, "\n") {
+		return errors.New("The log changed during the review; nothing was changed")
+	}
+
+	l := newLog(lines)
+	var migrate []int
+	var completed, cancelled int
+	for i, answer := range answers {
+		switch answer {
+		case 'm':
+			migrate = append(migrate, i)
+		case 'd':
+			if err := l.Complete(i); err != nil {
+				return err
+			}
+			completed++
+		case 'c':
+			lines[i] = cancelledMark + taskText(lines[i])
+			cancelled++
+		}
+	}
+	lines, err = migrateLines(l.Lines, migrate, today)
+	if err != nil {
+		return err
+	}
+	if err := log.Save(lines); err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %d, completed %d and cancelled %d tasks\n", len(migrate), completed, cancelled)
+	return nil
+}
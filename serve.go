@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+)
+
+// maxRequestBody is the most a request to the server may send.
+const maxRequestBody = 64 << 20
+
+// serveLog serves the log over HTTP until it is stopped: its API, see
+// routes, and with --sync the log at /sync for `blt sync --remote URL` to
+// merge with. Every request must carry the token set with
+// BULLETLOG_SERVE_TOKEN as a bearer token.
+func serveLog(c *cli.Context) error {
+	token := getSetting("BULLETLOG_SERVE_TOKEN")
+	if token == "" {
+		return errors.New("No token set for clients; set `token` under [serve] in config.toml")
+	}
+	addr := c.String("addr")
+	if setting := getSetting("BULLETLOG_SERVE_ADDR"); setting != "" && !c.IsSet("addr") {
+		addr = setting
+	}
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	// The log is locked for each request rather than while serving.
+	releaseLock(path)
+
+	s := &logServer{path: path}
+	mux := http.NewServeMux()
+	s.routes(mux)
+	if c.Bool("sync") {
+		stamps, err := readStamps(path)
+		if err != nil {
+			return err
+		}
+		if stamps == nil {
+			if err := writeStamps(path, entryStamps{}); err != nil {
+				return err
+			}
+		}
+		mux.HandleFunc("/sync", s.serveSync)
+	}
+	fmt.Printf("Serving %s on %s\n", path, addr)
+	return ioError(http.ListenAndServe(addr, requireToken(token, mux)))
+}
+
+// requireToken lets through to h only the requests carrying token.
+func requireToken(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="blt"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// logServer serves the log at path, to one request at a time.
+type logServer struct {
+	path string
+	mu   sync.Mutex
+}
+
+// lock takes the log for a request, from the other requests and from other
+// blt processes. The returned function lets go of it.
+func (s *logServer) lock() (func(), error) {
+	s.mu.Lock()
+	unlock, err := lockLog(s.path)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		s.mu.Unlock()
+	}, nil
+}
+
+// serverError answers a request that failed on the server's side.
+func serverError(w http.ResponseWriter, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// serveSync sends the log with its stamps on GET, and takes the log merged
+// by the client with its stamps on PUT. A PUT must be made on the condition
+// that the log is as the client read it, by its ETag. The log it sends is
+// saved as a change like any other, so it can be undone on the server.
+func (s *logServer) serveSync(w http.ResponseWriter, r *http.Request) {
+	unlock, err := s.lock()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer unlock()
+
+	log, err := newStore(nil, s.path, "sync")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	lines, err := log.Load()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	stamps, err := readStamps(s.path)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	tag := fmt.Sprintf(`"%x"`, logSum(lines))
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("ETag", tag)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(syncPayload{lines, stamps})
+	case http.MethodPut:
+		switch match := r.Header.Get("If-Match"); {
+		case match == "":
+			http.Error(w, "A PUT needs If-Match with the ETag of the log read", http.StatusPreconditionRequired)
+			return
+		case match != tag:
+			http.Error(w, "The log changed since it was read", http.StatusPreconditionFailed)
+			return
+		}
+		var p syncPayload
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := newLog(p.Lines).Sections(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := log.Save(p.Lines); err != nil {
+			serverError(w, err)
+			return
+		}
+		if p.Stamps == nil {
+			p.Stamps = entryStamps{}
+		}
+		if err := writeStamps(s.path, p.Stamps); err != nil {
+			serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+
+	// Registers the sqlite3 driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStorage keeps a log in a SQLite database, a row per line so that the
+// layout comes back as it was saved. The rows of entries have columns for
+// what they hold, and their tags a table of their own, for queries on the
+// database itself.
+type sqliteStorage struct{}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS lines (
+	id INTEGER PRIMARY KEY,
+	pos INTEGER NOT NULL UNIQUE,
+	line TEXT NOT NULL,
+	section TEXT,
+	date TEXT,
+	type TEXT,
+	status TEXT,
+	text TEXT,
+	entry_id INTEGER
+);
+CREATE INDEX IF NOT EXISTS lines_date ON lines (date);
+CREATE INDEX IF NOT EXISTS lines_type ON lines (type, status);
+CREATE INDEX IF NOT EXISTS lines_entry_id ON lines (entry_id);
+CREATE TABLE IF NOT EXISTS tags (
+	line INTEGER NOT NULL REFERENCES lines (id),
+	tag TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tags_tag ON tags (tag);
+CREATE INDEX IF NOT EXISTS tags_line ON tags (line);
+`
+
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, ioError(err)
+	}
+	return db, nil
+}
+
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func loadSQLiteLines(db sqlQueryer) ([]string, error) {
+	rows, err := db.Query(`SELECT line FROM lines ORDER BY pos`)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, ioError(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, ioError(rows.Err())
+}
+
+func (sqliteStorage) Load(path string) ([]string, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return loadSQLiteLines(db)
+}
+
+// Save replaces only the lines that changed, moving the rows after them.
+func (sqliteStorage) Save(path string, lines []string) error {
+	db, err := openSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ioError(err)
+	}
+	defer tx.Rollback()
+
+	current, err := loadSQLiteLines(tx)
+	if err != nil {
+		return err
+	}
+	ch := newChange("", current, lines)
+	end := ch.Start + len(ch.Removed)
+	shift := len(ch.Added) - len(ch.Removed)
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM tags WHERE line IN (SELECT id FROM lines WHERE pos >= ? AND pos < ?)`, []interface{}{ch.Start, end}},
+		{`DELETE FROM lines WHERE pos >= ? AND pos < ?`, []interface{}{ch.Start, end}},
+		// Positions are unique, so the rows after the change move through
+		// negative positions rather than onto each other.
+		{`UPDATE lines SET pos = -(pos + ?) WHERE pos >= ?`, []interface{}{shift, end}},
+		{`UPDATE lines SET pos = -pos WHERE pos < 0`, nil},
+	}
+	if shift == 0 {
+		statements = statements[:2]
+	}
+	for _, s := range statements {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			return ioError(err)
+		}
+	}
+
+	insertLine, err := tx.Prepare(`INSERT INTO lines (pos, line, section, date, type, status, text, entry_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return ioError(err)
+	}
+	defer insertLine.Close()
+	insertTag, err := tx.Prepare(`INSERT INTO tags (line, tag) VALUES (?, ?)`)
+	if err != nil {
+		return ioError(err)
+	}
+	defer insertTag.Close()
+
+	header := ""
+	var date *time.Time
+	for i := ch.Start - 1; i >= 0; i-- {
+		if bulletlog.IsHeader(lines[i]) {
+			header, date = lines[i], sectionDate(lines, i)
+			break
+		}
+	}
+	for n, line := range ch.Added {
+		i := ch.Start + n
+		if bulletlog.IsHeader(line) {
+			header, date = line, sectionDate(lines, i)
+		}
+		var section, day, typ, status, text, entryID interface{}
+		if header != "" {
+			section = header
+		}
+		if date != nil {
+			day = date.Format(dateFormat)
+		}
+		var tags []string
+		if isBullet(line) {
+			e := newJSONEntry(line, 0, date)
+			typ, text, tags = e.Type, e.Text, e.Tags
+			if e.Status != "" {
+				status = e.Status
+			}
+			if e.ID != 0 {
+				entryID = e.ID
+			}
+		}
+		res, err := insertLine.Exec(i, line, section, day, typ, status, text, entryID)
+		if err != nil {
+			return ioError(err)
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return ioError(err)
+		}
+		for _, tag := range tags {
+			if _, err := insertTag.Exec(id, tag); err != nil {
+				return ioError(err)
+			}
+		}
+	}
+
+	// The lines after the change up to the next header belong to the
+	// section the change left them in.
+	next := ch.Start + len(ch.Added)
+	for next < len(lines) && !bulletlog.IsHeader(lines[next]) {
+		next++
+	}
+	var section, day interface{}
+	if header != "" {
+		section = header
+	}
+	if date != nil {
+		day = date.Format(dateFormat)
+	}
+	if _, err := tx.Exec(`UPDATE lines SET section = ?, date = ? WHERE pos >= ? AND pos < ?`, section, day, ch.Start+len(ch.Added), next); err != nil {
+		return ioError(err)
+	}
+	return ioError(tx.Commit())
+}
+
+// sqliteStore is the store of a log kept in SQLite. It searches the rows of
+// entries, leaving out the other lines, rather than loading the whole log.
+type sqliteStore struct {
+	*lineStore
+}
+
+func (s sqliteStore) Search(terms []string, match func(line string) bool) ([]foundEntry, error) {
+	db, err := openSQLite(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT pos, line, section, date FROM lines WHERE type IS NOT NULL ORDER BY pos`)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	defer rows.Close()
+
+	var found []foundEntry
+	// Only entries hold continuation lines, so those before an entry tell the
+	// line of the file it starts on.
+	continued := 0
+	for rows.Next() {
+		var e foundEntry
+		var section, date sql.NullString
+		if err := rows.Scan(&e.Index, &e.Line, &section, &date); err != nil {
+			return nil, ioError(err)
+		}
+		e.Number = e.Index + 1 + continued
+		continued += strings.Count(e.Line, "\n")
+		if !match(e.Line) {
+			continue
+		}
+		e.Header = section.String
+		if date.Valid {
+			if t, err := time.Parse(dateFormat, date.String); err == nil {
+				e.Date = &t
+			}
+		}
+		found = append(found, e)
+	}
+	return found, ioError(rows.Err())
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+	"github.com/urfave/cli/v2"
+)
+
+// A store is the log as commands read and change it, whichever storage
+// keeps it.
+type store interface {
+	// Load returns the lines of the log.
+	Load() ([]string, error)
+	// Save replaces the lines of the log, recording the change, or with
+	// --dry-run prints it instead.
+	Save(lines []string) error
+	// AppendEntry adds entry to the section for date, under slot unless it
+	// is "", starting the day if there is no section for it yet. It returns
+	// the entry with the ID it was given.
+	AppendEntry(date time.Time, slot string, entry string) (string, error)
+	// UpdateEntry replaces the entry find finds with what update makes of it.
+	UpdateEntry(find locator, update func(line string) (string, error)) error
+	// ListSections returns the sections of the log with their entries.
+	ListSections() ([]bulletlog.Section, error)
+	// Search returns the entries match accepts, in the order of the log.
+	// Every entry match accepts contains each of terms, ignoring case, which
+	// a store may use to narrow its search.
+	Search(terms []string, match func(line string) bool) ([]foundEntry, error)
+}
+
+// A locator finds an entry in the lines of a log and returns its index.
+type locator func(lines []string) (int, error)
+
+// taskLocator finds an open task by number or `id:N`, as findTaskRef does.
+func taskLocator(ref string, reverse bool) locator {
+	return func(lines []string) (int, error) { return findTaskRef(lines, ref, reverse) }
+}
+
+// entryLocator finds any entry by number or `id:N`, as findEntry does.
+func entryLocator(ref string, reverse bool) locator {
+	return func(lines []string) (int, error) { return findEntry(lines, ref, reverse) }
+}
+
+// foundEntry is an entry found by Search.
+type foundEntry struct {
+	// Index is the index of the entry in the lines of the log, and Number
+	// the line of the file it starts on, counting from 1.
+	Index  int
+	Number int
+	Line   string
+	// Header is the header of the section holding the entry, and Date its
+	// date, nil for the undated sections.
+	Header string
+	Date   *time.Time
+}
+
+// openStore returns the store of the log for the command run with c.
+func openStore(c *cli.Context) (store, error) {
+	path, err := getLogPath()
+	if err != nil {
+		return nil, err
+	}
+	return newStore(c, path, c.Command.FullName())
+}
+
+// newStore returns the store of the log at path, whose changes are recorded
+// as made by command. c may be nil outside of a command's flags.
+func newStore(c *cli.Context, path string, command string) (store, error) {
+	st, err := getStorage()
+	if err != nil {
+		return nil, err
+	}
+	s := &lineStore{c: c, path: path, command: command, storage: st}
+	if _, ok := st.(sqliteStorage); ok {
+		return sqliteStore{s}, nil
+	}
+	return s, nil
+}
+
+// lineStore is a store over a storage that loads and saves the log whole.
+type lineStore struct {
+	c       *cli.Context
+	path    string
+	command string
+	storage storage
+}
+
+func (s *lineStore) Load() ([]string, error) {
+	return s.storage.Load(s.path)
+}
+
+func (s *lineStore) Save(lines []string) error {
+	current, err := s.Load()
+	if err != nil {
+		return err
+	}
+	if s.c != nil && s.c.Bool("dry-run") {
+		return printChange(s.path, current, lines)
+	}
+	return saveChange(s.c, s.path, s.command, current, lines)
+}
+
+func (s *lineStore) AppendEntry(date time.Time, slot string, entry string) (string, error) {
+	lines, err := s.Load()
+	if err != nil {
+		return "", err
+	}
+	_, found, err := findSection(lines, date)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		lines, err = startDay(lines, date)
+		if err != nil {
+			return "", err
+		}
+	}
+	entry = withID(lines, entry)
+	lines, err = insertBullet(lines, date, slot, entry)
+	if err != nil {
+		return "", err
+	}
+	return entry, s.Save(lines)
+}
+
+func (s *lineStore) UpdateEntry(find locator, update func(line string) (string, error)) error {
+	lines, err := s.Load()
+	if err != nil {
+		return err
+	}
+	i, err := find(lines)
+	if err != nil {
+		return err
+	}
+	lines[i], err = update(lines[i])
+	if err != nil {
+		return err
+	}
+	return s.Save(lines)
+}
+
+func (s *lineStore) ListSections() ([]bulletlog.Section, error) {
+	lines, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	sections, err := newLog(lines).Sections()
+	return sections, parseError(err)
+}
+
+func (s *lineStore) Search(terms []string, match func(line string) bool) ([]foundEntry, error) {
+	if searchIndexEnabled() {
+		return searchIndexed(s.path, s.Load, terms, match)
+	}
+	lines, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	return searchLines(lines, match), nil
+}
+
+// searchLines returns the entries in lines match accepts.
+func searchLines(lines []string, match func(line string) bool) []foundEntry {
+	var found []foundEntry
+	header := ""
+	var date *time.Time
+	numbers := bulletlog.LineNumbers(lines)
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			header, date = line, sectionDate(lines, i)
+			continue
+		}
+		if isBullet(line) && match(line) {
+			found = append(found, foundEntry{i, numbers[i], line, header, date})
+		}
+	}
+	return found
+}
+
+// A storage keeps the lines of a log at a path.
+type storage interface {
+	// Load returns the lines of the log at path.
+	Load(path string) ([]string, error)
+	// Save replaces the lines of the log at path.
+	Save(path string, lines []string) error
+}
+
+// A splitStorage keeps a log in more than one file.
+type splitStorage interface {
+	storage
+	// Files returns the files the log at path is kept in.
+	Files(path string) ([]string, error)
+}
+
+// textStorage keeps a log as the plain-text file at its path.
+type textStorage struct{}
+
+func (textStorage) Load(path string) ([]string, error) { return readLines(path) }
+
+func (textStorage) Save(path string, lines []string) error { return writeLines(path, lines) }
+
+// storages are the ways a log can be kept, by the name `storage` in
+// config.toml gives them.
+var storages = map[string]storage{
+	"text":    textStorage{},
+	"sqlite":  sqliteStorage{},
+	"monthly": monthlyStorage{},
+}
+
+func storageNames() []string {
+	names := make([]string, 0, len(storages))
+	for name := range storages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getStorage returns the store the log is kept in, as set with
+// BULLETLOG_STORAGE. The log is a plain-text file by default.
+func getStorage() (storage, error) {
+	name := getSetting("BULLETLOG_STORAGE")
+	if name == "" {
+		name = "text"
+	}
+	return lookupStorage(name)
+}
+
+func lookupStorage(name string) (storage, error) {
+	s, ok := storages[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown storage: %q; use one of %s", name, strings.Join(storageNames(), ", "))
+	}
+	return s, nil
+}
+
+// readLog returns the lines of the log at path from the storage keeping it.
+func readLog(path string) ([]string, error) {
+	s, err := getStorage()
+	if err != nil {
+		return nil, err
+	}
+	return s.Load(path)
+}
+
+// logFiles returns the files the log at path is kept in, which is only path
+// unless its storage splits it.
+func logFiles(path string) ([]string, error) {
+	s, err := getStorage()
+	if err != nil {
+		return nil, err
+	}
+	if split, ok := s.(splitStorage); ok {
+		return split.Files(path)
+	}
+	return []string{path}, nil
+}
+
+// writeLog replaces the lines of the log at path in the storage keeping it.
+func writeLog(path string, lines []string) error {
+	s, err := getStorage()
+	if err != nil {
+		return err
+	}
+	return s.Save(path, lines)
+}
+
+// openLog returns a reader of the log at path in its plain-text layout, for
+// the commands that scan it line by line.
+func openLog(path string) (io.Reader, error) {
+	lines, err := readLog(path)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return strings.NewReader(b.String()), nil
+}
+
+// convertLog copies the log into a new log at the path given, kept in the
+// storage named with --storage. Point `file` and `storage` in config.toml at it
+// to switch.
+func convertLog(c *cli.Context) error {
+	to, err := lookupStorage(c.String("storage"))
+	if err != nil {
+		return err
+	}
+	target := c.Args().First()
+	if target == "" {
+		return errors.New("Usage: blt convert --storage NAME PATH")
+	}
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	lines, err := readLog(path)
+	if err != nil {
+		return err
+	}
+	if err := to.Save(target, lines); err != nil {
+		return err
+	}
+	fmt.Printf("Copied %d lines to %s\n", len(lines), target)
+	return nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thara/blt/pkg/bulletlog"
+	"github.com/urfave/cli/v2"
+)
+
+// A syncRemote is the other copy of the log sync merges with.
+type syncRemote interface {
+	// Load returns the lines of the remote log, or nothing when there is
+	// none yet, with the stamps of its entries when it keeps them.
+	Load() ([]string, entryStamps, error)
+	// Save replaces the lines of the remote log, and its stamps when it
+	// keeps them.
+	Save(lines []string, stamps entryStamps) error
+}
+
+// newRemote returns the remote at an http or https URL, or else the file at
+// a path, such as a copy of the log in a shared folder.
+func newRemote(remote string) (syncRemote, error) {
+	if strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://") {
+		return &httpRemote{url: remote}, nil
+	}
+	path, err := filepath.Abs(expandHome(remote))
+	if err != nil {
+		return nil, ioError(err)
+	}
+	return fileRemote(path), nil
+}
+
+// fileRemote is a plain-text log at a path.
+type fileRemote string
+
+func (r fileRemote) Load() ([]string, entryStamps, error) {
+	lines, err := readArchive(string(r))
+	return lines, nil, err
+}
+
+func (r fileRemote) Save(lines []string, stamps entryStamps) error {
+	return writeLines(string(r), lines)
+}
+
+// httpRemote is a log read with GET and replaced with PUT: a plain-text log,
+// or the log served by `blt serve --sync` as a syncPayload, which carries its
+// stamps. The PUT is made on the condition that the log is still as it was
+// read, by its ETag, so that a change made meanwhile is not overwritten.
+// Requests carry the token set with BULLETLOG_SYNC_TOKEN, if any.
+type httpRemote struct {
+	url  string
+	etag string
+	// missing is whether there was no log at the URL, and payload whether
+	// the log came as a syncPayload.
+	missing bool
+	payload bool
+}
+
+// syncPayload is the log as `blt serve --sync` sends and takes it.
+type syncPayload struct {
+	Lines  []string    `json:"lines"`
+	Stamps entryStamps `json:"stamps"`
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func (r *httpRemote) do(req *http.Request) (*http.Response, error) {
+	if token := getSetting("BULLETLOG_SYNC_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	return resp, nil
+}
+
+func (r *httpRemote) Load() ([]string, entryStamps, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, nil, ioError(err)
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		r.missing = true
+		return nil, nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, ioError(fmt.Errorf("GET %s: %s", r.url, resp.Status))
+	}
+	r.etag = resp.Header.Get("ETag")
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		r.payload = true
+		var p syncPayload
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			return nil, nil, ioError(fmt.Errorf("GET %s: %v", r.url, err))
+		}
+		if p.Stamps == nil {
+			p.Stamps = entryStamps{}
+		}
+		return p.Lines, p.Stamps, nil
+	}
+	l, err := parser.Parse(resp.Body)
+	if err != nil {
+		return nil, nil, ioError(err)
+	}
+	return l.Lines, nil, nil
+}
+
+func (r *httpRemote) Save(lines []string, stamps entryStamps) error {
+	var body bytes.Buffer
+	contentType := "text/plain; charset=utf-8"
+	if r.payload {
+		contentType = "application/json"
+		if err := json.NewEncoder(&body).Encode(syncPayload{lines, stamps}); err != nil {
+			return err
+		}
+	} else if err := newLog(lines).Write(&body); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, r.url, &body)
+	if err != nil {
+		return ioError(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case r.missing:
+		req.Header.Set("If-None-Match", "*")
+	case r.etag != "":
+		req.Header.Set("If-Match", r.etag)
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return ioError(errors.New("The remote log changed during the sync; sync again"))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ioError(fmt.Errorf("PUT %s: %s", r.url, resp.Status))
+	}
+	return nil
+}
+
+// syncBasePath returns where the log at path keeps what it was when last
+// synced with remote, the base its changes and the remote's are told from.
+func syncBasePath(path string, remote string) string {
+	sum := sha1.Sum([]byte(remote))
+	return fmt.Sprintf("%s.sync-%x", path, sum[:6])
+}
+
+// The stamps of a log are kept next to it once it syncs with `blt serve
+// --sync`, or is served so: when each entry was last changed, by the key
+// syncEntries gives it, so that of two changes to an entry the later can win.
+func stampsPath(path string) string { return path + ".stamps" }
+
+type entryStamps map[string]time.Time
+
+// readStamps returns the stamps of the log at path, nil when it keeps none.
+func readStamps(path string) (entryStamps, error) {
+	data, err := ioutil.ReadFile(stampsPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ioError(err)
+	}
+	stamps := entryStamps{}
+	if err := json.Unmarshal(data, &stamps); err != nil {
+		return nil, parseError(fmt.Errorf("%s: %v", stampsPath(path), err))
+	}
+	return stamps, nil
+}
+
+func writeStamps(path string, stamps entryStamps) error {
+	return writeFile(stampsPath(path), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(stamps)
+	})
+}
+
+// updateStamps stamps the entries a change to the log at path from before to
+// after added or changed with t, when the log keeps stamps.
+func updateStamps(path string, before []string, after []string, t time.Time) error {
+	stamps, err := readStamps(path)
+	if err != nil || stamps == nil {
+		return err
+	}
+	was := syncEntries(before)
+	is := syncEntries(after)
+	for k, e := range is {
+		if old, ok := was[k]; !ok || old.line != e.line {
+			stamps[k] = t
+		}
+	}
+	for k := range stamps {
+		if _, ok := is[k]; !ok {
+			delete(stamps, k)
+		}
+	}
+	return writeStamps(path, stamps)
+}
+
+// mergeStamps returns the stamps of the entries of merged, each the later of
+// its stamps in a and b.
+func mergeStamps(merged []string, a entryStamps, b entryStamps) entryStamps {
+	stamps := entryStamps{}
+	for k := range syncEntries(merged) {
+		t, ok := a[k]
+		if u, found := b[k]; found && (!ok || u.After(t)) {
+			t, ok = u, true
+		}
+		if ok {
+			stamps[k] = t
+		}
+	}
+	return stamps
+}
+
+// syncedEntry is an entry of a copy of the log, by its index in the lines.
+type syncedEntry struct {
+	index int
+	line  string
+}
+
+// syncEntries returns the entries of lines by what tells the same entry
+// apart in every copy of the log: the header of its section with its ID, or
+// with its text when it has none, counting the same text in a section. The
+// marker is left out, so that an entry whose status changed is still the
+// same entry.
+func syncEntries(lines []string) map[string]syncedEntry {
+	entries := map[string]syncedEntry{}
+	seen := map[string]int{}
+	header := ""
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			header = line
+			continue
+		}
+		if !isBullet(line) {
+			continue
+		}
+		key := header + "\n" + entryKey(taskText(line))
+		if id, ok := getID(line); ok {
+			key = fmt.Sprintf("%s\nid:%d", header, id)
+		}
+		seen[key]++
+		entries[fmt.Sprintf("%s\n%d", key, seen[key])] = syncedEntry{i, line}
+	}
+	return entries
+}
+
+// syncLines merges the changes made to base in local and in remote, entry by
+// entry, and returns the merged lines with how many changes were taken from
+// remote and a description of each conflict. An entry changed in both is
+// kept as local has it, or as remote has it when preferRemote says so for its
+// key. One removed on a side and changed on the other is kept as changed.
+//
+// Only entries are merged: the rest of the log is as local has it, with a
+// section added for the entries remote adds to days local does not have.
+func syncLines(base []string, local []string, remote []string, preferRemote func(key string) bool) ([]string, int, []string, error) {
+	b, l, r := syncEntries(base), syncEntries(local), syncEntries(remote)
+	merged := append([]string{}, local...)
+	var removed []int
+	add := map[int]bool{}
+	taken := 0
+	var conflicts []string
+
+	keys := make([]string, 0, len(l)+len(r))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	for k := range r {
+		if _, ok := l[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		be, inB := b[k]
+		le, inL := l[k]
+		re, inR := r[k]
+		same := func(x syncedEntry, inX bool, y syncedEntry, inY bool) bool {
+			return inX == inY && x.line == y.line
+		}
+		switch {
+		case same(le, inL, re, inR), same(re, inR, be, inB):
+			continue
+		case same(le, inL, be, inB), !inL:
+			// Remote changed the entry, or changed one local removed.
+		case !inB:
+			// Both added an entry under the key.
+			add[re.index] = true
+			taken++
+			continue
+		case !inR:
+			continue
+		default:
+			kept, other := le.line, re.line
+			takeRemote := preferRemote(k)
+			if takeRemote {
+				kept, other = other, kept
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%q over %q", kept, other))
+			if !takeRemote {
+				continue
+			}
+		}
+		taken++
+		switch {
+		case !inR:
+			removed = append(removed, le.index)
+		case inL:
+			merged[le.index] = re.line
+		default:
+			add[re.index] = true
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(removed)))
+	for _, i := range removed {
+		merged = removeLine(merged, i)
+	}
+	merged, _, err := mergeSections(merged, addedLines(remote, add))
+	return merged, taken, conflicts, err
+}
+
+// addedLines returns the entries of lines at the indexes in add as a log of
+// their own, under the headers and sub-headers they are under in lines.
+func addedLines(lines []string, add map[int]bool) []string {
+	var log []string
+	header, slot := "", ""
+	lastHeader, lastSlot := "", ""
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			header, slot = line, ""
+			continue
+		}
+		if t, err := bulletlog.ParseSubHeader(line); err == nil {
+			slot = t
+			continue
+		}
+		if !add[i] {
+			continue
+		}
+		if header != lastHeader {
+			log = append(log, header, "")
+			lastHeader, lastSlot = header, ""
+		}
+		if slot != lastSlot {
+			log = append(log, bulletlog.SubHeader(slot)...)
+			lastSlot = slot
+		}
+		log = append(log, line, "")
+	}
+	return log
+}
+
+// getSyncRemote returns the remote the log is synced with instead of git:
+// --remote, or `remote` under [sync] in config.toml.
+func getSyncRemote(c *cli.Context) string {
+	if remote := c.String("remote"); remote != "" {
+		return remote
+	}
+	return getSetting("BULLETLOG_SYNC_REMOTE")
+}
+
+// syncWithRemote merges the log with the remote log, both ways, from the
+// base kept since they were last synced: the log takes the remote's changes
+// and the remote is replaced with the merged log. Without a base, as on the
+// first sync, nothing is removed from either.
+//
+// An entry changed on both sides is kept as --prefer says: as the side that
+// changed it last has it, by the stamps both keep when the remote is served
+// by blt, or else as local has it; or always as local or remote has it.
+func syncWithRemote(c *cli.Context, remote string) error {
+	prefer := c.String("prefer")
+	if prefer != "newer" && prefer != "local" && prefer != "remote" {
+		return fmt.Errorf("Invalid --prefer: %q; use newer, local or remote", prefer)
+	}
+	r, err := newRemote(remote)
+	if err != nil {
+		return err
+	}
+	log, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+	basePath := syncBasePath(path, remote)
+	base, err := readArchive(basePath)
+	if err != nil {
+		return err
+	}
+	local, err := log.Load()
+	if err != nil {
+		return err
+	}
+	localStamps, err := readStamps(path)
+	if err != nil {
+		return err
+	}
+	remoteLines, remoteStamps, err := r.Load()
+	if err != nil {
+		return err
+	}
+
+	preferRemote := func(key string) bool {
+		switch prefer {
+		case "remote":
+			return true
+		case "newer":
+			l, r := localStamps[key], remoteStamps[key]
+			return !l.IsZero() && r.After(l)
+		}
+		return false
+	}
+	merged, taken, conflicts, err := syncLines(base, local, remoteLines, preferRemote)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		fmt.Printf("Conflict: kept %s\n", conflict)
+	}
+	if err := log.Save(merged); err != nil {
+		return err
+	}
+	changed := strings.Join(merged, "\n") != strings.Join(remoteLines, "\n")
+	if c.Bool("dry-run") {
+		if changed {
+			return printChange(remote, remoteLines, merged)
+		}
+		return nil
+	}
+	// Once synced with a remote that keeps stamps, the log keeps them too.
+	var stamps entryStamps
+	if remoteStamps != nil || localStamps != nil {
+		stamps = mergeStamps(merged, localStamps, remoteStamps)
+		if err := writeStamps(path, stamps); err != nil {
+			return err
+		}
+	}
+	if changed || remoteLines == nil {
+		if err := r.Save(merged, stamps); err != nil {
+			return err
+		}
+	}
+	if err := writeLines(basePath, merged); err != nil {
+		return err
+	}
+	fmt.Printf("Synced with %s: took %d changes, %d conflicts\n", remote, taken, len(conflicts))
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Taskwarrior exchanges tasks as the JSON array `task export` prints and
+// `task import` reads. Pending and waiting tasks map to open blt tasks,
+// completed ones to done and deleted ones to cancelled. Tags map to blt tags,
+// the high priority to blt's ! priority and the project to a project:NAME
+// token. A task is filed under the date it was entered, and its annotations
+// follow it as notes.
+
+const taskwarriorTimeFormat = "20060102T150405Z"
+
+var projectPattern = regexp.MustCompile(`(?:^|\s)project:(\S+)(?:\s|$)`)
+
+type taskwarriorAnnotation struct {
+	Entry       string `json:"entry"`
+	Description string `json:"description"`
+}
+
+type taskwarriorTask struct {
+	UUID        string                  `json:"uuid"`
+	Description string                  `json:"description"`
+	Status      string                  `json:"status"`
+	Entry       string                  `json:"entry"`
+	Due         string                  `json:"due,omitempty"`
+	End         string                  `json:"end,omitempty"`
+	Priority    string                  `json:"priority,omitempty"`
+	Project     string                  `json:"project,omitempty"`
+	Tags        []string                `json:"tags,omitempty"`
+	Annotations []taskwarriorAnnotation `json:"annotations,omitempty"`
+}
+
+// parseTaskwarriorDate returns the local date of a Taskwarrior timestamp.
+func parseTaskwarriorDate(s string) (time.Time, error) {
+	t, err := time.Parse(taskwarriorTimeFormat, s)
+	if err != nil {
+		return t, err
+	}
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// taskwarriorUUID returns a stable UUID for an entry, derived from its ID or,
+// for an entry without one, from its date and text.
+func taskwarriorUUID(e exportEntry) string {
+	key := fmt.Sprintf("id:%d", e.ID)
+	if e.ID == 0 {
+		key = e.Date + " " + e.Text
+	}
+	h := sha1.Sum([]byte("blt " + key))
+	h[6] = h[6]&0x0f | 0x50
+	h[8] = h[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// exportTaskwarrior writes the tasks as Taskwarrior JSON. Migrated copies are
+// left out, and tasks of undated sections are entered today.
+func exportTaskwarrior(w io.Writer, sections []exportSection) error {
+	today, err := getDate()
+	if err != nil {
+		return err
+	}
+
+	tasks := []taskwarriorTask{}
+	for _, section := range sections {
+		entered := today
+		if section.Date != nil {
+			entered = *section.Date
+		}
+		for _, e := range section.Entries {
+			if e.Type != "task" || e.Status == "migrated" {
+				continue
+			}
+
+			text := e.Text
+			task := taskwarriorTask{
+				UUID:   taskwarriorUUID(e),
+				Status: "pending",
+				Entry:  entered.Format(taskwarriorTimeFormat),
+				Tags:   e.Tags,
+			}
+			if getSignifier(text) == prioritySignifier {
+				task.Priority = "H"
+				text = strings.TrimPrefix(text, prioritySignifier+" ")
+			}
+			if m := projectPattern.FindStringSubmatch(text); m != nil {
+				task.Project = m[1]
+			}
+			if due, ok := getDue(text); ok {
+				task.Due = due.Format(taskwarriorTimeFormat)
+			}
+			switch e.Status {
+			case "done":
+				task.Status = "completed"
+				task.End = task.Entry
+				if m := donePattern.FindStringSubmatch(text); m != nil {
+					t, _ := time.Parse(dateFormat, m[1])
+					task.End = t.Format(taskwarriorTimeFormat)
+				}
+			case "cancelled":
+				task.Status = "deleted"
+				task.End = task.Entry
+			}
+
+			text = projectPattern.ReplaceAllString(text, " ")
+			text = duePattern.ReplaceAllString(text, " ")
+			text = donePattern.ReplaceAllString(text, " ")
+			text = tagPattern.ReplaceAllString(text, " ")
+			task.Description = strings.Join(strings.Fields(text), " ")
+			tasks = append(tasks, task)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tasks)
+}
+
+// importTaskwarrior reads the tasks of `task export`. Recurring task
+// templates are skipped; their instances are imported like other tasks.
+func importTaskwarrior(r io.Reader, today time.Time) ([]importedEntry, error) {
+	var tasks []taskwarriorTask
+	if err := json.NewDecoder(r).Decode(&tasks); err != nil {
+		return nil, err
+	}
+
+	var entries []importedEntry
+	for n, task := range tasks {
+		mark := openMark
+		switch task.Status {
+		case "pending", "waiting":
+		case "completed":
+			mark = doneMark
+		case "deleted":
+			mark = cancelledMark
+		case "recurring":
+			continue
+		default:
+			return nil, fmt.Errorf("task %d: unknown status %q", n+1, task.Status)
+		}
+
+		date := today
+		if task.Entry != "" {
+			t, err := parseTaskwarriorDate(task.Entry)
+			if err != nil {
+				return nil, fmt.Errorf("task %d: %v", n+1, err)
+			}
+			date = t
+		}
+
+		words := []string{task.Description}
+		if task.Priority == "H" {
+			words = append([]string{prioritySignifier}, words...)
+		}
+		if task.Project != "" {
+			words = append(words, "project:"+task.Project)
+		}
+		for _, tag := range task.Tags {
+			words = append(words, "#"+tag)
+		}
+		if task.Due != "" {
+			t, err := parseTaskwarriorDate(task.Due)
+			if err != nil {
+				return nil, fmt.Errorf("task %d: %v", n+1, err)
+			}
+			words = append(words, "due:"+t.Format(dateFormat))
+		}
+		if task.Status == "completed" && task.End != "" {
+			t, err := parseTaskwarriorDate(task.End)
+			if err != nil {
+				return nil, fmt.Errorf("task %d: %v", n+1, err)
+			}
+			words = append(words, "done:"+t.Format(dateFormat))
+		}
+		entries = append(entries, importedEntry{date, mark + strings.Join(words, " ")})
+
+		for _, a := range task.Annotations {
+			entries = append(entries, importedEntry{date, fmt.Sprintf("%s %s", markers["note"], a.Description)})
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// todo.txt keeps a task per line, such as
+//
+//	x 2026-10-14 2026-10-12 (A) call mom +family @phone due:2026-10-20
+//
+// with its completion and creation dates, priority, +projects, @contexts and
+// key:value pairs. Projects map to blt tags and contexts stay as they are,
+// since blt has none. blt has a single priority, which any todo.txt priority
+// maps to, and files a task under its creation date. The completion date,
+// which blt does not keep otherwise, is kept in a done:YYYYMMDD token.
+
+const todoTxtDateFormat = "2006-01-02"
+
+var (
+	donePattern        = regexp.MustCompile(`(?:^|\s)done:(\d{8})(?:\s|$)`)
+	todoTxtPriority    = regexp.MustCompile(`^\(([A-Z])\) `)
+	todoTxtDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)
+	todoTxtDuePattern  = regexp.MustCompile(`^due:(\d{4}-\d{2}-\d{2})$`)
+	todoTxtPriPattern  = regexp.MustCompile(`^pri:[A-Z]$`)
+	todoTxtTagReplacer = regexp.MustCompile(`(^|\s)#([\p{L}\p{N}_-]+)`)
+	todoTxtDueReplacer = regexp.MustCompile(`(^|\s)due:(\d{4})(\d{2})(\d{2})(\s|$)`)
+)
+
+// exportTodoTxt writes the tasks as todo.txt. Open and in-progress tasks are
+// written as open ones and done tasks as completed ones; migrated copies and
+// cancelled tasks are left out.
+func exportTodoTxt(w io.Writer, sections []exportSection) error {
+	for _, section := range sections {
+		for _, e := range section.Entries {
+			if e.Type != "task" || e.Status == "migrated" || e.Status == "cancelled" {
+				continue
+			}
+
+			var parts []string
+			created := ""
+			if section.Date != nil {
+				created = section.Date.Format(todoTxtDateFormat)
+			}
+			// A todo.txt task is a single line.
+			text := strings.ReplaceAll(e.Text, "\n", " ")
+			priority := getSignifier(text) == prioritySignifier
+			text = strings.TrimPrefix(text, prioritySignifier+" ")
+
+			if e.Status == "done" {
+				parts = append(parts, "x")
+				completed := created
+				if m := donePattern.FindStringSubmatch(text); m != nil {
+					t, _ := time.Parse(dateFormat, m[1])
+					completed = t.Format(todoTxtDateFormat)
+				}
+				if completed != "" {
+					parts = append(parts, completed)
+				}
+			} else if priority {
+				parts = append(parts, "(A)")
+			}
+			if created != "" {
+				parts = append(parts, created)
+			}
+
+			text = donePattern.ReplaceAllString(text, " ")
+			text = todoTxtTagReplacer.ReplaceAllString(text, "$1+$2")
+			text = todoTxtDueReplacer.ReplaceAllString(text, "${1}due:$2-$3-$4$5")
+			parts = append(parts, strings.Join(strings.Fields(text), " "))
+			if e.Status == "done" && priority {
+				parts = append(parts, "pri:A")
+			}
+			fmt.Fprintln(w, strings.Join(parts, " "))
+		}
+	}
+	return nil
+}
+
+// importTodoTxt reads todo.txt tasks as blt tasks filed under their creation
+// dates. A task without one is filed under its completion date, if done, or
+// today.
+func importTodoTxt(r io.Reader, today time.Time) ([]importedEntry, error) {
+	var entries []importedEntry
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		done := strings.HasPrefix(line, "x ")
+		var completed string
+		priority := false
+		if done {
+			line = strings.TrimPrefix(line, "x ")
+			if todoTxtDatePattern.MatchString(line) {
+				completed, line = line[:10], line[11:]
+			}
+		}
+		if m := todoTxtPriority.FindStringSubmatch(line); m != nil {
+			priority = true
+			line = line[len(m[0]):]
+		}
+		created := completed
+		if todoTxtDatePattern.MatchString(line) {
+			created, line = line[:10], line[11:]
+		}
+		date := today
+		if created != "" {
+			t, err := time.Parse(todoTxtDateFormat, created)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			date = t
+		}
+
+		var words []string
+		for _, word := range strings.Fields(line) {
+			switch {
+			case todoTxtPriPattern.MatchString(word):
+				priority = true
+				continue
+			case strings.HasPrefix(word, "+") && len(word) > 1:
+				word = "#" + word[1:]
+			case todoTxtDuePattern.MatchString(word):
+				t, err := time.Parse(todoTxtDateFormat, todoTxtDuePattern.FindStringSubmatch(word)[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				word = "due:" + t.Format(dateFormat)
+			}
+			words = append(words, word)
+		}
+		text := strings.Join(words, " ")
+		if priority {
+			text = prioritySignifier + " " + text
+		}
+
+		entry := openMark + text
+		if done {
+			entry = doneMark + text
+			if completed != "" {
+				t, err := time.Parse(todoTxtDateFormat, completed)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				entry += " done:" + t.Format(dateFormat)
+			}
+		}
+		entries = append(entries, importedEntry{date, entry})
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/thara/blt/pkg/bulletlog"
+	"github.com/urfave/cli/v2"
+)
+
+const uiHelp = "a note  t task  x complete  m migrate  e edit  / search  enter fold  q quit"
+
+// ui is the state of `blt ui`: the log shown as a tree of sections and their
+// entries, reloaded from the file after every change.
+type ui struct {
+	app    *tview.Application
+	tree   *tview.TreeView
+	footer *tview.TextView
+	layout *tview.Flex
+
+	path   string
+	log    store
+	filter string
+}
+
+func runUI(c *cli.Context) error {
+	path, err := getLogPath()
+	if err != nil {
+		return err
+	}
+
+	// The UI locks the log only while it changes it, not while it is open.
+	releaseLock(path)
+	log, err := newStore(nil, path, "ui")
+	if err != nil {
+		return err
+	}
+
+	u := &ui{
+		app:    tview.NewApplication(),
+		tree:   tview.NewTreeView(),
+		footer: tview.NewTextView().SetDynamicColors(true),
+		path:   path,
+		log:    log,
+	}
+	u.layout = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(u.tree, 0, 1, true).
+		AddItem(u.footer, 1, 0, false)
+
+	u.tree.SetSelectedFunc(func(node *tview.TreeNode) {
+		if node.GetReference() == nil {
+			node.SetExpanded(!node.IsExpanded())
+		}
+	})
+	u.tree.SetInputCapture(u.handleKey)
+
+	if err := u.reload(); err != nil {
+		return err
+	}
+	u.footer.SetText(uiHelp)
+	return u.app.SetRoot(u.layout, true).Run()
+}
+
+// reload rebuilds the tree from the log, keeping folded sections folded and
+// the cursor on the same line where possible.
+func (u *ui) reload() error {
+	lines, err := u.log.Load()
+	if err != nil {
+		return err
+	}
+
+	selected := -1
+	if node := u.tree.GetCurrentNode(); node != nil {
+		if i, ok := node.GetReference().(int); ok {
+			selected = i
+		}
+	}
+	folded := map[string]bool{}
+	if root := u.tree.GetRoot(); root != nil {
+		for _, node := range root.GetChildren() {
+			if !node.IsExpanded() {
+				folded[node.GetText()] = true
+			}
+		}
+	}
+
+	root := tview.NewTreeNode("")
+	var section, current *tview.TreeNode
+	for i, line := range lines {
+		if bulletlog.IsHeader(line) {
+			section = tview.NewTreeNode(strings.TrimPrefix(line, "## ")).
+				SetColor(tcell.ColorYellow)
+			section.SetExpanded(!folded[section.GetText()])
+			root.AddChild(section)
+			continue
+		}
+		if section == nil || !isBullet(line) {
+			continue
+		}
+		if u.filter != "" && !strings.Contains(strings.ToLower(taskText(line)), strings.ToLower(u.filter)) {
+			continue
+		}
+		// The tree shows an entry by its first line.
+		text, more := splitEntry(line)
+		if more != "" {
+			text += " …"
+		}
+		node := tview.NewTreeNode(tview.Escape(text)).SetReference(i)
+		section.AddChild(node)
+		if i == selected || current == nil && i > selected && selected >= 0 {
+			current = node
+		}
+	}
+
+	if u.filter != "" {
+		// Leave out sections without a match.
+		var matched []*tview.TreeNode
+		for _, node := range root.GetChildren() {
+			if len(node.GetChildren()) > 0 {
+				matched = append(matched, node)
+			}
+		}
+		root.SetChildren(matched)
+	}
+
+	u.tree.SetRoot(root).SetTopLevel(1)
+	if current == nil && len(root.GetChildren()) > 0 {
+		current = root.GetChildren()[0]
+	}
+	u.tree.SetCurrentNode(current)
+	return nil
+}
+
+func (u *ui) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	if event.Key() == tcell.KeyEscape && u.filter != "" {
+		u.filter = ""
+		u.update(nil)
+		return nil
+	}
+
+	switch event.Rune() {
+	case 'q':
+		u.app.Stop()
+	case 'a':
+		u.prompt("Note: ", "", func(text string) { u.update(u.add(markers["note"], text)) })
+	case 't':
+		u.prompt("Task: ", "", func(text string) { u.update(u.add(markers["task"], text)) })
+	case 'x':
+		u.update(u.change(func(lines []string, i int) ([]string, error) {
+			l := newLog(lines)
+			err := l.Complete(i)
+			return l.Lines, err
+		}))
+	case 'm':
+		u.update(u.change(func(lines []string, i int) ([]string, error) {
+			if !isListedTask(lines[i]) {
+				return nil, fmt.Errorf("Line %d is not an open task", bulletlog.LineNumber(lines, i))
+			}
+			today, err := getDate()
+			if err != nil {
+				return nil, err
+			}
+			return migrateLines(lines, []int{i}, today)
+		}))
+	case 'e':
+		i, ok := u.selected()
+		if !ok {
+			return nil
+		}
+		// Only the first line of an entry is edited here; its continuation
+		// lines are kept.
+		first, _ := splitEntry(taskText(u.line(i)))
+		text := strings.TrimSpace(idPattern.ReplaceAllString(first, ""))
+		u.prompt("Edit: ", text, func(text string) {
+			u.update(u.change(func(lines []string, i int) ([]string, error) {
+				_, rest := splitEntry(lines[i])
+				lines[i] = replaceText(lines[i], text+rest)
+				return lines, nil
+			}))
+		})
+	case '/':
+		u.prompt("Search: ", u.filter, func(text string) {
+			u.filter = text
+			u.update(nil)
+		})
+	default:
+		return event
+	}
+	return nil
+}
+
+// prompt asks for a line of text in the footer, and calls done with it unless
+// the user presses Esc.
+func (u *ui) prompt(label string, text string, done func(text string)) {
+	input := tview.NewInputField().SetLabel(label).SetText(text)
+	input.SetDoneFunc(func(key tcell.Key) {
+		u.layout.RemoveItem(input)
+		u.layout.AddItem(u.footer, 1, 0, false)
+		u.app.SetFocus(u.tree)
+		if key == tcell.KeyEnter && strings.TrimSpace(input.GetText()) != "" {
+			done(strings.TrimSpace(input.GetText()))
+		}
+	})
+	u.layout.RemoveItem(u.footer)
+	u.layout.AddItem(input, 1, 0, true)
+	u.app.SetFocus(input)
+}
+
+// update reloads the tree and shows err, if any, in the footer.
+func (u *ui) update(err error) {
+	if err == nil {
+		err = u.reload()
+	}
+	if err != nil {
+		u.footer.SetText("[red]" + tview.Escape(err.Error()))
+		return
+	}
+	u.footer.SetText(uiHelp)
+}
+
+// selected returns the line index of the entry under the cursor.
+func (u *ui) selected() (int, bool) {
+	node := u.tree.GetCurrentNode()
+	if node == nil {
+		return 0, false
+	}
+	i, ok := node.GetReference().(int)
+	return i, ok
+}
+
+func (u *ui) line(i int) string {
+	lines, err := u.log.Load()
+	if err != nil || i >= len(lines) {
+		return ""
+	}
+	return lines[i]
+}
+
+func (u *ui) add(mark string, text string) error {
+	unlock, err := lockLog(u.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	date, err := getDate()
+	if err != nil {
+		return err
+	}
+	_, err = u.log.AppendEntry(date, "", fmt.Sprintf("%s %s", mark, text))
+	return err
+}
+
+// change rewrites the log with fn applied to the entry under the cursor.
+func (u *ui) change(fn func(lines []string, i int) ([]string, error)) error {
+	i, ok := u.selected()
+	if !ok {
+		return nil
+	}
+	unlock, err := lockLog(u.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	lines, err := u.log.Load()
+	if err != nil {
+		return err
+	}
+	if i >= len(lines) {
+		return fmt.Errorf("The log changed; line %d is gone", i+1)
+	}
+	lines, err = fn(lines, i)
+	if err != nil {
+		return err
+	}
+	return u.log.Save(lines)
+}
+
+// pickTasks lets the user choose open tasks from a list narrowed by fuzzy
+// matching as they type. Tab marks a task; Enter returns the marked tasks, or
+// the highlighted one if none are marked. It returns the tasks' line indexes,
+// or nothing when the user presses Esc.
+func pickTasks(lines []string) ([]int, error) {
+	tasks := newLog(lines).OpenTasks()
+	if len(tasks) == 0 {
+		return nil, errors.New("No open tasks")
+	}
+
+	app := tview.NewApplication()
+	input := tview.NewInputField().SetLabel("Complete: ")
+	list := tview.NewList().ShowSecondaryText(false).SetHighlightFullLine(true)
+	marked := map[int]bool{}
+	var shown []int
+	var picked []int
+
+	refresh := func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		shown = shown[:0]
+		for n, i := range tasks {
+			text := taskText(lines[i])
+			if !fuzzyMatch(input.GetText(), text) {
+				continue
+			}
+			mark := " "
+			if marked[i] {
+				mark = "*"
+			}
+			list.AddItem(fmt.Sprintf("%s %d: %s", mark, n, tview.Escape(text)), "", 0, nil)
+			shown = append(shown, i)
+		}
+		if current < list.GetItemCount() {
+			list.SetCurrentItem(current)
+		}
+	}
+	input.SetChangedFunc(func(string) { refresh() })
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		current := list.GetCurrentItem()
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			list.InputHandler()(event, nil)
+			return nil
+		case tcell.KeyTab:
+			if current < len(shown) {
+				marked[shown[current]] = !marked[shown[current]]
+				refresh()
+				list.SetCurrentItem(current + 1)
+			}
+			return nil
+		case tcell.KeyEnter:
+			for _, i := range tasks {
+				if marked[i] {
+					picked = append(picked, i)
+				}
+			}
+			if len(picked) == 0 && current < len(shown) {
+				picked = append(picked, shown[current])
+			}
+			app.Stop()
+			return nil
+		case tcell.KeyEscape:
+			app.Stop()
+			return nil
+		}
+		return event
+	})
+	refresh()
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(input, 1, 0, true).
+		AddItem(list, 0, 1, false)
+	if err := app.SetRoot(layout, true).Run(); err != nil {
+		return nil, err
+	}
+	return picked, nil
+}
+
+// fuzzyMatch reports whether the characters of pattern appear in text in
+// order, ignoring case.
+func fuzzyMatch(pattern string, text string) bool {
+	text = strings.ToLower(text)
+	for _, r := range strings.ToLower(pattern) {
+		i := strings.IndexRune(text, r)
+		if i < 0 {
+			return false
+		}
+		text = text[i+len(string(r)):]
+	}
+	return true
+}